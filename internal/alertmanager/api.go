@@ -0,0 +1,206 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// Matcher matches the value of a label, see https://github.com/prometheus/alertmanager/blob/main/api/v2/openapi.yaml
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+// String returns the matcher in the Alertmanager syntax, e.g. 'severity=~"critical|warning"'.
+func (m Matcher) String() string {
+	matchType := labels.MatchEqual
+	switch {
+	case m.IsRegex && m.IsEqual:
+		matchType = labels.MatchRegexp
+	case m.IsRegex:
+		matchType = labels.MatchNotRegexp
+	case !m.IsEqual:
+		matchType = labels.MatchNotEqual
+	}
+	matcher := labels.Matcher{Type: matchType, Name: m.Name, Value: m.Value}
+	return matcher.String()
+}
+
+// ParseMatchers parses matchers in the Alertmanager syntax, e.g. '{alertname="down",severity=~"critical|warning"}'.
+func ParseMatchers(text string) ([]Matcher, error) {
+	parsed, err := labels.ParseMatchers(text)
+	if err != nil {
+		return nil, err
+	}
+	var matchers []Matcher
+	for _, matcher := range parsed {
+		matchers = append(matchers, Matcher{
+			Name:    matcher.Name,
+			Value:   matcher.Value,
+			IsRegex: matcher.Type == labels.MatchRegexp || matcher.Type == labels.MatchNotRegexp,
+			IsEqual: matcher.Type == labels.MatchRegexp || matcher.Type == labels.MatchEqual,
+		})
+	}
+	return matchers, nil
+}
+
+// Silence mutes all alerts matching its matchers between StartsAt and EndsAt. Status is only set for silences
+// returned by the API.
+type Silence struct {
+	ID        string         `json:"id,omitempty"`
+	Matchers  []Matcher      `json:"matchers"`
+	StartsAt  time.Time      `json:"startsAt"`
+	EndsAt    time.Time      `json:"endsAt"`
+	CreatedBy string         `json:"createdBy"`
+	Comment   string         `json:"comment"`
+	Status    *SilenceStatus `json:"status,omitempty"`
+}
+
+type SilenceStatus struct {
+	State string `json:"state"`
+}
+
+const SilenceStateActive = "active"
+
+// Alert is an alert known to Alertmanager. Status is only set for alerts returned by the API.
+type Alert struct {
+	Fingerprint string        `json:"fingerprint"`
+	Labels      amtemplate.KV `json:"labels"`
+	Annotations amtemplate.KV `json:"annotations"`
+	StartsAt    time.Time     `json:"startsAt"`
+	EndsAt      time.Time     `json:"endsAt"`
+	Status      *AlertStatus  `json:"status,omitempty"`
+}
+
+type AlertStatus struct {
+	State string `json:"state"`
+}
+
+const AlertStateSuppressed = "suppressed"
+
+// Suppressed returns whether the alert is silenced or inhibited.
+func (a Alert) Suppressed() bool {
+	return a.Status != nil && a.Status.State == AlertStateSuppressed
+}
+
+// LabelMatchers returns matchers for the exact values of all given labels sorted by their name.
+func LabelMatchers(labels amtemplate.KV) []Matcher {
+	var matchers []Matcher
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		matchers = append(matchers, Matcher{Name: name, Value: labels[name], IsEqual: true})
+	}
+	return matchers
+}
+
+// API is a client for the v2 API of a single Alertmanager.
+type API struct {
+	url    string
+	client *http.Client
+}
+
+func NewAPI(baseURL string) API {
+	return API{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// CreateSilence creates the given silence and returns its ID.
+func (a API) CreateSilence(ctx context.Context, silence Silence) (string, error) {
+	var response struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := a.do(ctx, http.MethodPost, "/api/v2/silences", silence, &response); err != nil {
+		return "", fmt.Errorf("could not create silence: %w", err)
+	}
+	return response.SilenceID, nil
+}
+
+// Alerts returns all alerts which are neither resolved, silenced nor inhibited.
+func (a API) Alerts(ctx context.Context) ([]Alert, error) {
+	var alerts []Alert
+	if err := a.do(ctx, http.MethodGet, "/api/v2/alerts?active=true&silenced=false&inhibited=false", nil, &alerts); err != nil {
+		return nil, fmt.Errorf("could not fetch alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// UnresolvedAlerts returns all alerts which are not resolved, including silenced and inhibited alerts.
+func (a API) UnresolvedAlerts(ctx context.Context) ([]Alert, error) {
+	var alerts []Alert
+	if err := a.do(ctx, http.MethodGet, "/api/v2/alerts?active=true&silenced=true&inhibited=true", nil, &alerts); err != nil {
+		return nil, fmt.Errorf("could not fetch alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// Silences returns all silences including expired ones.
+func (a API) Silences(ctx context.Context) ([]Silence, error) {
+	var silences []Silence
+	if err := a.do(ctx, http.MethodGet, "/api/v2/silences", nil, &silences); err != nil {
+		return nil, fmt.Errorf("could not fetch silences: %w", err)
+	}
+	return silences, nil
+}
+
+// ExpireSilence expires the silence with the given ID.
+func (a API) ExpireSilence(ctx context.Context, silenceID string) error {
+	if err := a.do(ctx, http.MethodDelete, "/api/v2/silence/"+url.PathEscape(silenceID), nil, nil); err != nil {
+		return fmt.Errorf("could not expire silence %s: %w", silenceID, err)
+	}
+	return nil
+}
+
+// do sends the given body as JSON to the API and decodes the response into result unless it is nil.
+func (a API) do(ctx context.Context, method string, path string, body any, result any) error {
+	var requestBody io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(content)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, a.url+path, requestBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if !slices.Contains([]int{http.StatusOK, http.StatusCreated, http.StatusAccepted}, response.StatusCode) {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("alertmanager responded with %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,141 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelMatchers(t *testing.T) {
+	assert.Equal(t, []Matcher{
+		{Name: "alertname", Value: "down", IsEqual: true},
+		{Name: "instance", Value: "example.com", IsEqual: true},
+	}, LabelMatchers(amtemplate.KV{"instance": "example.com", "alertname": "down"}))
+	assert.Empty(t, LabelMatchers(amtemplate.KV{}))
+}
+
+func TestAPI_CreateSilence(t *testing.T) {
+	silence := Silence{
+		Matchers:  []Matcher{{Name: "alertname", Value: "down", IsEqual: true}},
+		StartsAt:  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndsAt:    time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC),
+		CreatedBy: "@alice:example.com",
+		Comment:   "silenced from Matrix",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, http.MethodPost, request.Method)
+		assert.Equal(t, "/api/v2/silences", request.URL.Path)
+		var received Silence
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&received))
+		assert.Equal(t, silence, received)
+		_, _ = writer.Write([]byte(`{"silenceID":"1234"}`))
+	}))
+	defer server.Close()
+
+	silenceID, err := NewAPI(server.URL+"/").CreateSilence(t.Context(), silence)
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", silenceID)
+}
+
+func TestAPI_CreateSilence_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		http.Error(writer, "invalid matchers", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := NewAPI(server.URL).CreateSilence(t.Context(), Silence{})
+	assert.ErrorContains(t, err, "invalid matchers")
+}
+
+func TestParseMatchers(t *testing.T) {
+	matchers, err := ParseMatchers(`{alertname="down", severity=~"critical|warning", instance!="a", job!~"b.*"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []Matcher{
+		{Name: "alertname", Value: "down", IsEqual: true},
+		{Name: "severity", Value: "critical|warning", IsRegex: true, IsEqual: true},
+		{Name: "instance", Value: "a"},
+		{Name: "job", Value: "b.*", IsRegex: true},
+	}, matchers)
+
+	var formatted []string
+	for _, matcher := range matchers {
+		formatted = append(formatted, matcher.String())
+	}
+	assert.Equal(t, []string{`alertname="down"`, `severity=~"critical|warning"`, `instance!="a"`, `job!~"b.*"`}, formatted)
+
+	_, err = ParseMatchers(`{alertname=~"["}`)
+	assert.Error(t, err)
+}
+
+func TestAPI_Alerts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, http.MethodGet, request.Method)
+		assert.Equal(t, "/api/v2/alerts", request.URL.Path)
+		assert.Equal(t, "true", request.URL.Query().Get("active"))
+		assert.Equal(t, "false", request.URL.Query().Get("silenced"))
+		_, _ = writer.Write([]byte(`[{"fingerprint":"abc","labels":{"alertname":"down"},"startsAt":"2025-01-01T00:00:00Z"}]`))
+	}))
+	defer server.Close()
+
+	alerts, err := NewAPI(server.URL).Alerts(t.Context())
+	assert.NoError(t, err)
+	assert.Equal(t, []Alert{{
+		Fingerprint: "abc",
+		Labels:      amtemplate.KV{"alertname": "down"},
+		StartsAt:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}, alerts)
+}
+
+func TestAPI_UnresolvedAlerts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		assert.Equal(t, "/api/v2/alerts", request.URL.Path)
+		assert.Equal(t, "true", request.URL.Query().Get("silenced"))
+		assert.Equal(t, "true", request.URL.Query().Get("inhibited"))
+		_, _ = writer.Write([]byte(`[
+			{"fingerprint":"abc","labels":{"alertname":"down"},"status":{"state":"active"}},
+			{"fingerprint":"def","labels":{"alertname":"slow"},"status":{"state":"suppressed"}}
+		]`))
+	}))
+	defer server.Close()
+
+	alerts, err := NewAPI(server.URL).UnresolvedAlerts(t.Context())
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 2)
+	assert.False(t, alerts[0].Suppressed())
+	assert.True(t, alerts[1].Suppressed())
+}
+
+func TestAPI_Silences(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			assert.Equal(t, "/api/v2/silences", request.URL.Path)
+			_, _ = writer.Write([]byte(`[{"id":"1234","matchers":[{"name":"alertname","value":"down","isRegex":false,"isEqual":true}],"status":{"state":"active"}}]`))
+		case http.MethodDelete:
+			assert.Equal(t, "/api/v2/silence/1234", request.URL.Path)
+		default:
+			t.Errorf("unexpected method %s", request.Method)
+		}
+	}))
+	defer server.Close()
+
+	api := NewAPI(server.URL)
+	silences, err := api.Silences(t.Context())
+	assert.NoError(t, err)
+	assert.Equal(t, []Silence{{
+		ID:       "1234",
+		Matchers: []Matcher{{Name: "alertname", Value: "down", IsEqual: true}},
+		Status:   &SilenceStatus{State: SilenceStateActive},
+	}}, silences)
+	assert.NoError(t, api.ExpireSilence(t.Context(), "1234"))
+}
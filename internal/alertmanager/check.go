@@ -0,0 +1,141 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+)
+
+// executable is a parsed HTML or text template. Templates written in Markdown are text templates as well.
+type executable interface {
+	Execute(writer io.Writer, data any) error
+}
+
+// parsingFunc parses either an HTML or a text template.
+type parsingFunc func(name string, text string) (executable, error)
+
+// SamplePayload returns a webhook payload with a firing and a resolved alert which is used to verify templates
+// without an Alertmanager.
+func SamplePayload() *Payload {
+	startsAt := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	groupLabels := amtemplate.KV{"alertname": "HighLatency"}
+	commonLabels := amtemplate.KV{"alertname": "HighLatency", "severity": "warning"}
+	commonAnnotations := amtemplate.KV{"summary": "Requests are slow"}
+	return &Payload{
+		Data: amtemplate.Data{
+			Receiver: "sample",
+			Status:   string(model.AlertFiring),
+			Alerts: amtemplate.Alerts{
+				{
+					Status:       string(model.AlertFiring),
+					Labels:       amtemplate.KV{"alertname": "HighLatency", "severity": "warning", "instance": "web-1:8080"},
+					Annotations:  amtemplate.KV{"summary": "Requests are slow", "description": "The p99 latency of web-1 is above 500ms"},
+					StartsAt:     startsAt,
+					GeneratorURL: "http://prometheus:9090/graph",
+					Fingerprint:  "a1b2c3d4e5f60718",
+				},
+				{
+					Status:       string(model.AlertResolved),
+					Labels:       amtemplate.KV{"alertname": "HighLatency", "severity": "warning", "instance": "web-2:8080"},
+					Annotations:  amtemplate.KV{"summary": "Requests are slow", "description": "The p99 latency of web-2 is above 500ms"},
+					StartsAt:     startsAt,
+					EndsAt:       startsAt.Add(15 * time.Minute),
+					GeneratorURL: "http://prometheus:9090/graph",
+					Fingerprint:  "0f1e2d3c4b5a6978",
+				},
+			},
+			GroupLabels:       groupLabels,
+			CommonLabels:      commonLabels,
+			CommonAnnotations: commonAnnotations,
+			ExternalURL:       "http://alertmanager:9093",
+		},
+		Version:  "4",
+		GroupKey: `{}:{alertname="HighLatency"}`,
+	}
+}
+
+// CheckTemplates compiles all configured templates including the shared template files and renders them against the
+// sample payload. It returns every problem found together with the YAML path of the affected template.
+func CheckTemplates(ctx context.Context, configuration config.Templating) []config.Problem {
+	var problems []config.Problem
+	if configuration.Files != "" {
+		if _, err := template.New("").Funcs(createTemplateFunctions(ctx)).ParseGlob(configuration.Files); err != nil {
+			problems = append(problems, config.Problem{Path: "templating.template-files", Message: strings.TrimPrefix(err.Error(), "template: ")})
+			return problems
+		}
+	}
+	check := func(prefix string, name string, file string, text string, data any, parse parsingFunc) bool {
+		path := prefix + name
+		if file != "" {
+			path += "-file"
+		}
+		report := func(err error) {
+			message := strings.TrimSpace(strings.TrimPrefix(err.Error(), "template: "+name+":"))
+			problems = append(problems, config.Problem{Path: path, Message: message})
+		}
+		parsed, err := parse(name, text)
+		if err != nil {
+			report(err)
+			return false
+		}
+		if err := parsed.Execute(io.Discard, data); err != nil {
+			report(err)
+			return false
+		}
+		return true
+	}
+	parseFormatted := func(format string) parsingFunc {
+		return func(name string, text string) (executable, error) {
+			return parseTemplate(ctx, configuration, format, name, text)
+		}
+	}
+	parseText := func(name string, text string) (executable, error) {
+		return parseTextTemplate(ctx, configuration, name, text)
+	}
+
+	sample := SamplePayload()
+	firing, resolved := sample.Alerts[0], sample.Alerts[1]
+	firingData := newTemplateData(ctx, configuration, firing, &sample.Data)
+	resolvedData := newTemplateData(ctx, configuration, resolved, &sample.Data)
+	checkPair := func(prefix string, templates config.NamedTemplate) {
+		parseMessage := parseFormatted(templates.Format)
+		firingValid := check(prefix, "firing-template", templates.FiringFile, templates.Firing, firingData, parseMessage)
+		if templates.Resolved != "" {
+			check(prefix, "resolved-template", templates.ResolvedFile, templates.Resolved, resolvedData, parseMessage)
+		} else if firingValid {
+			// the firing template is used for resolved alerts as well
+			check(prefix, "firing-template", templates.FiringFile, templates.Firing, resolvedData, parseMessage)
+		}
+		firingTextValid := true
+		if templates.FiringText != "" {
+			firingTextValid = check(prefix, "firing-text-template", templates.FiringTextFile, templates.FiringText, firingData, parseText)
+		}
+		if templates.ResolvedText != "" {
+			check(prefix, "resolved-text-template", templates.ResolvedTextFile, templates.ResolvedText, resolvedData, parseText)
+		} else if templates.Resolved == "" && templates.FiringText != "" && firingTextValid {
+			// the firing text template is used for resolved alerts as well
+			check(prefix, "firing-text-template", templates.FiringTextFile, templates.FiringText, resolvedData, parseText)
+		}
+	}
+
+	checkPair("templating.", defaultTemplates(configuration))
+	for index, named := range configuration.Templates {
+		checkPair(fmt.Sprintf("templating.templates[%d].", index), named)
+	}
+	if configuration.Group != "" {
+		check("templating.", "group-template", configuration.GroupFile, configuration.Group, newGroupTemplateData(ctx, configuration, &sample.Data), parseFormatted(configuration.Format))
+	}
+	return problems
+}
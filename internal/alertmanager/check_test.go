@@ -0,0 +1,87 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTemplates(t *testing.T) {
+	ctx := t.Context()
+	testCases := map[string]struct {
+		configuration config.Templating
+		expected      []config.Problem
+	}{
+		"valid": {
+			configuration: config.Templating{
+				Firing:   `{{ .Alert.Labels.alertname }} is firing`,
+				Resolved: `{{ .Alert.Labels.alertname }} is resolved`,
+				Group:    `{{ .FiringCount }} alerts firing`,
+			},
+		},
+		"unknown-function": {
+			configuration: config.Templating{
+				Firing: `{{ ToUpperr "firing" }}`,
+			},
+			expected: []config.Problem{
+				{Path: "templating.firing-template", Message: `1: function "ToUpperr" not defined`},
+			},
+		},
+		"unknown-field-in-fallback": {
+			configuration: config.Templating{
+				Firing: `{{ if eq .Alert.Status "resolved" }}{{ .Alert.Unknown }}{{ end }}`,
+			},
+			expected: []config.Problem{
+				{Path: "templating.firing-template", Message: `1:45: executing "firing-template" at <.Alert.Unknown>: can't evaluate field Unknown in type template.Alert`},
+			},
+		},
+		"named-templates": {
+			configuration: config.Templating{
+				Firing: `{{ .Alert.Labels.alertname }}`,
+				Templates: []config.NamedTemplate{
+					{Name: "valid", Firing: `{{ .Alert.Labels.alertname }}`},
+					{Name: "invalid", Firing: `{{ .Alert.Labels.alertname }}`, Resolved: `{{ .Alert.Unknown }}`},
+				},
+			},
+			expected: []config.Problem{
+				{Path: "templating.templates[1].resolved-template", Message: `1:9: executing "resolved-template" at <.Alert.Unknown>: can't evaluate field Unknown in type template.Alert`},
+			},
+		},
+		"text-templates": {
+			configuration: config.Templating{
+				Firing:     `{{ .Alert.Labels.alertname }}`,
+				FiringText: `{{ .Alert.Unknown }}`,
+				Templates: []config.NamedTemplate{
+					{Name: "invalid", Firing: `{{ .Alert.Labels.alertname }}`, ResolvedText: `{{ end }}`},
+				},
+			},
+			expected: []config.Problem{
+				{Path: "templating.firing-text-template", Message: `1:9: executing "firing-text-template" at <.Alert.Unknown>: can't evaluate field Unknown in type template.Alert`},
+				{Path: "templating.templates[0].resolved-text-template", Message: `1: unexpected {{end}}`},
+			},
+		},
+		"all-templates": {
+			configuration: config.Templating{
+				Firing:   `{{ .Alert.Unknown }}`,
+				Resolved: `{{ end }}`,
+				Group:    `{{ .Unknown }}`,
+			},
+			expected: []config.Problem{
+				{Path: "templating.firing-template", Message: `1:9: executing "firing-template" at <.Alert.Unknown>: can't evaluate field Unknown in type template.Alert`},
+				{Path: "templating.resolved-template", Message: `1: unexpected {{end}}`},
+				{Path: "templating.group-template", Message: `1:3: executing "group-template" at <.Unknown>: can't evaluate field Unknown in type alertmanager.groupTemplateData`},
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, CheckTemplates(ctx, testCase.configuration))
+		})
+	}
+}
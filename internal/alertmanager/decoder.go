@@ -12,8 +12,15 @@ import (
 	"github.com/prometheus/alertmanager/template"
 )
 
-func DecodePayload(requestBody io.ReadCloser) (*template.Data, error) {
-	payload := template.Data{}
+// Payload is the webhook payload sent by an Alertmanager.
+type Payload struct {
+	template.Data
+	Version  string `json:"version"`
+	GroupKey string `json:"groupKey"`
+}
+
+func DecodePayload(requestBody io.ReadCloser) (*Payload, error) {
+	payload := Payload{}
 	err := json.NewDecoder(requestBody).Decode(&payload)
 	return &payload, err
 }
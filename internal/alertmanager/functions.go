@@ -0,0 +1,112 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"time"
+
+	"github.com/prometheus/common/helpers/templates"
+	"github.com/prometheus/common/model"
+)
+
+// helperFunctions complement the Alertmanager default functions with the time, duration and number helpers known
+// from Prometheus alerting rules.
+func helperFunctions() template.FuncMap {
+	return template.FuncMap{
+		"toFloat":            templates.ConvertToFloat,
+		"humanize":           humanize,
+		"humanize1024":       humanize1024,
+		"humanizePercentage": humanizePercentage,
+		"humanizeTimestamp":  templates.HumanizeTimestamp,
+		"toTime":             toTime,
+		"until":              time.Until,
+		"parseDuration":      parseDuration,
+	}
+}
+
+// humanize formats a number with SI prefixes, e.g. 1234567 as 1.235M.
+func humanize(i any) (string, error) {
+	v, err := templates.ConvertToFloat(i)
+	if err != nil {
+		return "", err
+	}
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+	prefix := ""
+	if math.Abs(v) >= 1 {
+		for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
+			if math.Abs(v) < 1000 {
+				break
+			}
+			prefix = p
+			v /= 1000
+		}
+	} else {
+		for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+			if math.Abs(v) >= 1 {
+				break
+			}
+			prefix = p
+			v *= 1000
+		}
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix), nil
+}
+
+// humanize1024 formats a number with binary prefixes, e.g. 1048576 as 1Mi.
+func humanize1024(i any) (string, error) {
+	v, err := templates.ConvertToFloat(i)
+	if err != nil {
+		return "", err
+	}
+	if math.Abs(v) <= 1 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+	prefix := ""
+	for _, p := range []string{"ki", "Mi", "Gi", "Ti", "Pi", "Ei", "Zi", "Yi"} {
+		if math.Abs(v) < 1024 {
+			break
+		}
+		prefix = p
+		v /= 1024
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix), nil
+}
+
+// humanizePercentage formats a ratio as percentage, e.g. 0.1234 as 12.34%.
+func humanizePercentage(i any) (string, error) {
+	v, err := templates.ConvertToFloat(i)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%.4g%%", v*100), nil
+}
+
+// toTime converts a Unix timestamp in seconds into a time.
+func toTime(i any) (time.Time, error) {
+	v, err := templates.ConvertToFloat(i)
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, err := templates.FloatToTime(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return *t, nil
+}
+
+// parseDuration parses a Prometheus duration like 1d or 5m30s.
+func parseDuration(text string) (time.Duration, error) {
+	duration, err := model.ParseDuration(text)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(duration), nil
+}
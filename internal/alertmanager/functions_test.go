@@ -0,0 +1,103 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFunctions(t *testing.T) {
+	alert := amtemplate.Alert{
+		Status:      "firing",
+		Labels:      amtemplate.KV{"alertname": "disk full", "instance": "db-1", "job": "node"},
+		Annotations: amtemplate.KV{"value": "1234567", "bytes": "1073741824", "ratio": "0.1234"},
+		StartsAt:    time.Date(2024, time.January, 1, 12, 30, 0, 0, time.UTC),
+	}
+	testCases := map[string]struct {
+		templateStr string
+		expected    string
+	}{
+		"title": {
+			templateStr: `{{ .Alert.Labels.alertname | title }}`,
+			expected:    "Disk Full",
+		},
+		"toUpper": {
+			templateStr: `{{ .Alert.Labels.job | toUpper }}`,
+			expected:    "NODE",
+		},
+		"join-sorted-pairs": {
+			templateStr: `{{ .Alert.Labels.SortedPairs.Values | join ", " }}`,
+			expected:    "disk full, db-1, node",
+		},
+		"common-labels-sorted-pairs": {
+			templateStr: `{{ range .CommonLabels.SortedPairs }}{{ .Name }}={{ .Value }} {{ end }}`,
+			expected:    "alertname=disk full ",
+		},
+		"string-slice": {
+			templateStr: `{{ stringSlice "a" "b" | join "-" }}`,
+			expected:    "a-b",
+		},
+		"re-replace-all": {
+			templateStr: `{{ reReplaceAll "-[0-9]+$" "" .Alert.Labels.instance }}`,
+			expected:    "db",
+		},
+		"safe-html": {
+			templateStr: `{{ "<b>bold</b>" | safeHtml }}`,
+			expected:    "<b>bold</b>",
+		},
+		"date-tz": {
+			templateStr: `{{ .Alert.StartsAt | tz "Europe/Berlin" | date "15:04 MST" }}`,
+			expected:    "13:30 CET",
+		},
+		"humanize-duration": {
+			templateStr: `{{ humanizeDuration 3725 }}`,
+			expected:    "1h 2m 5s",
+		},
+		"humanize": {
+			templateStr: `{{ humanize .Alert.Annotations.value }}`,
+			expected:    "1.235M",
+		},
+		"humanize-small": {
+			templateStr: `{{ humanize 0.005 }}`,
+			expected:    "5m",
+		},
+		"humanize-1024": {
+			templateStr: `{{ humanize1024 .Alert.Annotations.bytes }}`,
+			expected:    "1Gi",
+		},
+		"humanize-percentage": {
+			templateStr: `{{ humanizePercentage .Alert.Annotations.ratio }}`,
+			expected:    "12.34%",
+		},
+		"to-time": {
+			templateStr: `{{ toTime 1704112200 | date "2006-01-02 15:04" }}`,
+			expected:    "2024-01-01 12:30",
+		},
+		"parse-duration": {
+			templateStr: `{{ parseDuration "1d" }}`,
+			expected:    "24h0m0s",
+		},
+		"to-float": {
+			templateStr: `{{ if gt (toFloat .Alert.Annotations.ratio) 0.1 }}high{{ end }}`,
+			expected:    "high",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			templatingFunc, err := CreateTemplatingFunc(context.Background(), config.Templating{Firing: testCase.templateStr})
+			assert.NoError(t, err)
+			output, err := templatingFunc(alert, &amtemplate.Data{CommonLabels: amtemplate.KV{"alertname": "disk full"}})
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, output.HTML)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"fmt"
+	"html/template"
+	"slices"
+	"strings"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"maunium.net/go/mautrix/event"
+	mautrixformat "maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+// mentionStyleText renders mentions as plain user IDs in text templates.
+const mentionStyleText = "text"
+
+// mentioner provides the mention helpers of templates. It renders mentions in the style of the executed template and
+// records all mentioned users, which are shared between the HTML and the text template of a message.
+type mentioner struct {
+	mapping  map[string]map[string]config.UserList
+	labels   []amtemplate.KV
+	style    string
+	mentions *event.Mentions
+}
+
+func newMentioner(configuration config.Templating, labels ...amtemplate.KV) mentioner {
+	return mentioner{
+		mapping:  configuration.Mentions,
+		labels:   labels,
+		mentions: &event.Mentions{},
+	}
+}
+
+// Mention mentions all users mapped to the values of the given label. Each user is mentioned once, even if multiple
+// alerts of a group map to the same user.
+func (m mentioner) Mention(label string) (template.HTML, error) {
+	var users []string
+	for _, labels := range m.labels {
+		for _, user := range m.mapping[label][labels[label]] {
+			if !slices.Contains(users, user) {
+				users = append(users, user)
+			}
+		}
+	}
+	var pills []string
+	for _, user := range users {
+		pill, err := m.MentionUser(user)
+		if err != nil {
+			return "", err
+		}
+		pills = append(pills, string(pill))
+	}
+	return template.HTML(strings.Join(pills, " ")), nil
+}
+
+// MentionUser mentions the given user.
+func (m mentioner) MentionUser(user string) (template.HTML, error) {
+	localpart, server, found := strings.Cut(strings.TrimPrefix(user, "@"), ":")
+	if !strings.HasPrefix(user, "@") || !found || localpart == "" || server == "" {
+		return "", fmt.Errorf("%q is not a user ID", user)
+	}
+	userID := id.UserID(user)
+	m.mentions.Add(userID)
+	switch m.style {
+	case mentionStyleText:
+		return template.HTML(user), nil
+	case config.FormatMarkdown:
+		return template.HTML(mautrixformat.MarkdownMention(userID)), nil
+	default:
+		return template.HTML(fmt.Sprintf(`<a href="%s">%s</a>`, template.HTMLEscapeString(userID.URI().MatrixToURL()), template.HTMLEscapeString(user))), nil
+	}
+}
+
+// MentionRoom mentions everyone in the room.
+func (m mentioner) MentionRoom() template.HTML {
+	m.mentions.Room = true
+	return "@room"
+}
+
+// collected returns all mentions recorded while rendering, or nil in case no mention helper was used.
+func (m mentioner) collected() *event.Mentions {
+	if len(m.mentions.UserIDs) == 0 && !m.mentions.Room {
+		return nil
+	}
+	return m.mentions
+}
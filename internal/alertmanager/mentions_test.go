@@ -0,0 +1,121 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package alertmanager
+
+import (
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestMentions(t *testing.T) {
+	mentions := map[string]map[string]config.UserList{
+		"owner": {
+			"alice": {"@alice:example.com"},
+		},
+		"team": {
+			"db": {"@bob:example.com", "@alice:example.com"},
+		},
+	}
+	alert := amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "owner": "alice", "team": "db"}}
+	testCases := map[string]struct {
+		configuration config.Templating
+		expected      Rendered
+	}{
+		"html": {
+			configuration: config.Templating{
+				Firing: `{{ .Mention "owner" }} {{ .Alert.Labels.alertname }}`,
+			},
+			expected: Rendered{
+				HTML:     `<a href="https://matrix.to/#/@alice:example.com">@alice:example.com</a> down`,
+				Mentions: &event.Mentions{UserIDs: []id.UserID{"@alice:example.com"}},
+			},
+		},
+		"markdown": {
+			configuration: config.Templating{
+				Firing: `{{ .Mention "owner" }} {{ .Alert.Labels.alertname }}`,
+				Format: config.FormatMarkdown,
+			},
+			expected: Rendered{
+				HTML:     `<a href="https://matrix.to/#/@alice:example.com">@alice:example.com</a> down`,
+				Text:     `[@alice:example.com](https://matrix.to/#/@alice:example.com) down`,
+				Mentions: &event.Mentions{UserIDs: []id.UserID{"@alice:example.com"}},
+			},
+		},
+		"text": {
+			configuration: config.Templating{
+				Firing:     `{{ .Mention "team" }} {{ .Alert.Labels.alertname }}`,
+				FiringText: `{{ .Mention "team" }} {{ .Alert.Labels.alertname }}`,
+			},
+			expected: Rendered{
+				HTML:     `<a href="https://matrix.to/#/@bob:example.com">@bob:example.com</a> <a href="https://matrix.to/#/@alice:example.com">@alice:example.com</a> down`,
+				Text:     `@bob:example.com @alice:example.com down`,
+				Mentions: &event.Mentions{UserIDs: []id.UserID{"@bob:example.com", "@alice:example.com"}},
+			},
+		},
+		"room": {
+			configuration: config.Templating{
+				Firing: `{{ .MentionRoom }} {{ .MentionUser "@carol:example.com" }}`,
+			},
+			expected: Rendered{
+				HTML:     `@room <a href="https://matrix.to/#/@carol:example.com">@carol:example.com</a>`,
+				Mentions: &event.Mentions{UserIDs: []id.UserID{"@carol:example.com"}, Room: true},
+			},
+		},
+		"unmapped-value": {
+			configuration: config.Templating{
+				Firing: `{{ .Mention "alertname" }}down`,
+			},
+			expected: Rendered{
+				HTML: `down`,
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			testCase.configuration.Mentions = mentions
+			templatingFunc, err := CreateTemplatingFunc(t.Context(), testCase.configuration)
+			assert.NoError(t, err)
+			rendered, err := templatingFunc(alert, &amtemplate.Data{})
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, rendered)
+		})
+	}
+}
+
+func TestMentions_Group(t *testing.T) {
+	configuration := config.Templating{
+		Group: `{{ .Mention "owner" }}`,
+		Mentions: map[string]map[string]config.UserList{
+			"owner": {
+				"alice": {"@alice:example.com"},
+				"bob":   {"@bob:example.com"},
+			},
+		},
+	}
+	groupTemplatingFunc, err := CreateGroupTemplatingFunc(t.Context(), configuration)
+	assert.NoError(t, err)
+	rendered, err := groupTemplatingFunc(&amtemplate.Data{Alerts: amtemplate.Alerts{
+		{Status: "firing", Labels: amtemplate.KV{"owner": "alice"}},
+		{Status: "firing", Labels: amtemplate.KV{"owner": "bob"}},
+		{Status: "firing", Labels: amtemplate.KV{"owner": "alice"}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, []id.UserID{"@alice:example.com", "@bob:example.com"}, rendered.Mentions.UserIDs)
+}
+
+func TestMentions_InvalidUser(t *testing.T) {
+	templatingFunc, err := CreateTemplatingFunc(t.Context(), config.Templating{
+		Firing: `{{ .MentionUser "alice" }}`,
+	})
+	assert.NoError(t, err)
+	_, err = templatingFunc(amtemplate.Alert{Status: "firing"}, &amtemplate.Data{})
+	assert.ErrorContains(t, err, `"alice" is not a user ID`)
+}
@@ -16,12 +16,15 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	texttemplate "text/template"
 
 	"github.com/metio/matrix-alertmanager-receiver/internal/config"
 	amtemplate "github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
+	"maunium.net/go/mautrix/event"
+	mautrixformat "maunium.net/go/mautrix/format"
 )
 
 var (
@@ -35,121 +38,401 @@ var (
 	})
 )
 
-type TemplatingFunc func(alert amtemplate.Alert, data *amtemplate.Data) (string, error)
+type TemplatingFunc func(alert amtemplate.Alert, data *amtemplate.Data) (Rendered, error)
+
+type GroupTemplatingFunc func(data *amtemplate.Data) (Rendered, error)
+
+// Rendered is the output of a template. Text replaces the plain-text fallback which is otherwise derived from the
+// HTML and is empty unless the template produces a dedicated plain-text version. MessageType is empty unless a named
+// template sets the Matrix message type. Mentions contains the users and the room mentioned with the mention helpers
+// and is nil unless the template used them.
+type Rendered struct {
+	HTML        string
+	Text        string
+	MessageType string
+	Mentions    *event.Mentions
+}
+
+// ExplainingFunc describes how a single alert is rendered without rendering it.
+type ExplainingFunc func(alert amtemplate.Alert, data *amtemplate.Data) Explanation
+
+// Explanation contains the name of the template selected for an alert and the values available to that template.
+type Explanation struct {
+	Template       string            `json:"template"`
+	ComputedValues map[string]string `json:"computed-values"`
+	SilenceURL     string            `json:"silence-url"`
+}
 
 type templateData struct {
 	Alert             amtemplate.Alert
-	GroupLabels       map[string]string `json:"groupLabels"`
-	CommonLabels      map[string]string `json:"commonLabels"`
-	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	GroupLabels       amtemplate.KV `json:"groupLabels"`
+	CommonLabels      amtemplate.KV `json:"commonLabels"`
+	CommonAnnotations amtemplate.KV `json:"commonAnnotations"`
 	SilenceURL        string
 	ExternalURL       string
 	GeneratorURL      string
 	ComputedValues    map[string]string
+	mentioner
 }
 
-func CreateTemplatingFunc(ctx context.Context, configuration config.Templating) TemplatingFunc {
+type groupTemplateData struct {
+	Receiver          string
+	Status            string
+	Alerts            amtemplate.Alerts
+	GroupLabels       amtemplate.KV
+	CommonLabels      amtemplate.KV
+	CommonAnnotations amtemplate.KV
+	FiringCount       int
+	ResolvedCount     int
+	SilenceURL        string
+	ExternalURL       string
+	mentioner
+}
+
+// renderData is the data of a template which provides the mention helpers.
+type renderData interface {
+	withMentionStyle(style string) any
+	collected() *event.Mentions
+}
+
+func (d templateData) withMentionStyle(style string) any {
+	d.style = style
+	return d
+}
+
+func (d groupTemplateData) withMentionStyle(style string) any {
+	d.style = style
+	return d
+}
+
+func CreateTemplatingFunc(ctx context.Context, configuration config.Templating) (TemplatingFunc, error) {
 	slog.DebugContext(ctx, "Creating templating function", slog.Any("configuration", configuration.LogValue()))
 
-	templateFunctions := template.FuncMap{
-		"ToUpper":       strings.ToUpper,
-		"ToLower":       strings.ToLower,
-		"Replace":       func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
-		"RegexReplace":  func(pattern, replacement, s string) string {
-			re, err := regexp.Compile(pattern)
-			if err != nil {
-				slog.ErrorContext(ctx, "Invalid regex pattern", slog.String("pattern", pattern), slog.Any("error", err))
-				return s
-			}
-			return re.ReplaceAllString(s, replacement)
-		},
+	defaults, err := parseTemplatePair(ctx, configuration, defaultTemplates(configuration))
+	if err != nil {
+		return nil, err
 	}
-
-	firing := template.Must(template.New("firing").Funcs(templateFunctions).Parse(configuration.Firing))
-	resolvedTemplate := configuration.Resolved
-	if resolvedTemplate == "" {
-		resolvedTemplate = configuration.Firing
+	var named []templatePair
+	for _, namedTemplate := range configuration.Templates {
+		pair, err := parseTemplatePair(ctx, configuration, namedTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %s: %w", namedTemplate.Name, err)
+		}
+		named = append(named, pair)
 	}
-	resolved := template.Must(template.New("resolved").Funcs(templateFunctions).Parse(resolvedTemplate))
 
-	return func(alert amtemplate.Alert, data *amtemplate.Data) (string, error) {
-		selectedTemplate := firing
+	return func(alert amtemplate.Alert, data *amtemplate.Data) (Rendered, error) {
+		pair := defaults
+		if index := selectNamedTemplate(configuration, alert); index >= 0 {
+			pair = named[index]
+		}
+		selectedTemplate, selectedTextTemplate, messageType := pair.firing, pair.firingText, pair.firingMessageType
 		if alert.Status == string(model.AlertResolved) {
-			selectedTemplate = resolved
-		}
-
-		externalUrl := maybeMapValue(data.ExternalURL, configuration.ExternalURLMapping)
-		slog.DebugContext(ctx, "ExternalURL mapped",
-			slog.String("original-url", data.ExternalURL),
-			slog.String("mapped-url", externalUrl))
-
-		generatorUrl := maybeMapValue(alert.GeneratorURL, configuration.GeneratorURLMapping)
-		slog.DebugContext(ctx, "GeneratorURL mapped",
-			slog.String("original-url", data.ExternalURL),
-			slog.String("mapped-url", externalUrl))
-
-		silenceUrl := silenceURL(alert, externalUrl)
-		slog.DebugContext(ctx, "Silence URL computed", slog.String("silence-url", silenceUrl))
-
-		values := computeValues(alert, configuration.ComputedValues)
-		slog.DebugContext(ctx, "Values computed", slog.Any("values", values))
-
-		var output bytes.Buffer
-		err := selectedTemplate.Execute(&output, templateData{
-			Alert:             alert,
-			GroupLabels:       data.GroupLabels,
-			CommonLabels:      data.CommonLabels,
-			CommonAnnotations: data.CommonAnnotations,
-			SilenceURL:        silenceUrl,
-			ExternalURL:       externalUrl,
-			GeneratorURL:      generatorUrl,
-			ComputedValues:    values,
-		})
+			selectedTemplate, selectedTextTemplate, messageType = pair.resolved, pair.resolvedText, pair.resolvedMessageType
+		}
+
+		rendered, err := render(selectedTemplate, selectedTextTemplate, pair.format, newTemplateData(ctx, configuration, alert, data))
 		if err != nil {
 			templatingFailureTotal.Inc()
 			slog.ErrorContext(ctx, "Cannot template given data", slog.Any("error", err))
-			return "", err
+			return Rendered{}, err
 		}
 		templatingSuccessTotal.Inc()
-		return output.String(), nil
+		rendered.MessageType = messageType
+		return rendered, nil
+	}, nil
+}
+
+// templatePair contains the templates for firing and resolved alerts, the format they are written in, and the message
+// types of their messages. The text templates are nil unless a dedicated plain-text version is configured.
+type templatePair struct {
+	format              string
+	firing              executable
+	resolved            executable
+	firingText          *texttemplate.Template
+	resolvedText        *texttemplate.Template
+	firingMessageType   string
+	resolvedMessageType string
+}
+
+// defaultTemplates returns the templates used for alerts that do not match any named template. Their message types are
+// decided together with the message type of the room, since they rank below it.
+func defaultTemplates(configuration config.Templating) config.NamedTemplate {
+	return config.NamedTemplate{
+		Format:           configuration.Format,
+		Firing:           configuration.Firing,
+		Resolved:         configuration.Resolved,
+		FiringText:       configuration.FiringText,
+		ResolvedText:     configuration.ResolvedText,
+		FiringFile:       configuration.FiringFile,
+		ResolvedFile:     configuration.ResolvedFile,
+		FiringTextFile:   configuration.FiringTextFile,
+		ResolvedTextFile: configuration.ResolvedTextFile,
 	}
 }
 
-func computeValues(alert amtemplate.Alert, values []config.ComputedValue) map[string]string {
-	computedValues := make(map[string]string)
-	for _, computer := range values {
-		if len(computer.Values) == 0 {
-			continue
+// parseTemplatePair parses the templates for firing and resolved alerts. The firing templates are used for resolved
+// alerts as well in case no resolved template is given.
+func parseTemplatePair(ctx context.Context, configuration config.Templating, templates config.NamedTemplate) (templatePair, error) {
+	prefix := ""
+	if templates.Name != "" {
+		prefix = templates.Name + "/"
+	}
+	firing, err := parseTemplate(ctx, configuration, templates.Format, prefix+"firing", templates.Firing)
+	if err != nil {
+		return templatePair{}, fmt.Errorf("invalid firing template: %w", err)
+	}
+	templates = withResolvedFallback(templates)
+	resolved, err := parseTemplate(ctx, configuration, templates.Format, prefix+"resolved", templates.Resolved)
+	if err != nil {
+		return templatePair{}, fmt.Errorf("invalid resolved template: %w", err)
+	}
+	pair := templatePair{
+		format:              templates.Format,
+		firing:              firing,
+		resolved:            resolved,
+		firingMessageType:   templates.FiringMessageType,
+		resolvedMessageType: templates.ResolvedMessageType,
+	}
+	if templates.FiringText != "" {
+		if pair.firingText, err = parseTextTemplate(ctx, configuration, prefix+"firing-text", templates.FiringText); err != nil {
+			return templatePair{}, fmt.Errorf("invalid firing text template: %w", err)
+		}
+	}
+	if templates.ResolvedText != "" {
+		if pair.resolvedText, err = parseTextTemplate(ctx, configuration, prefix+"resolved-text", templates.ResolvedText); err != nil {
+			return templatePair{}, fmt.Errorf("invalid resolved text template: %w", err)
 		}
+	}
+	return pair, nil
+}
 
-		statusMatches := true
-		if computer.StatusMatcher != "" {
-			if alert.Status != computer.StatusMatcher {
-				statusMatches = false
-			}
+// withResolvedFallback uses the firing templates for resolved alerts in case no resolved template is given. The
+// firing text template and message type are only used for resolved alerts in case the firing template is used for
+// them as well, otherwise they would not match the resolved template.
+func withResolvedFallback(templates config.NamedTemplate) config.NamedTemplate {
+	if templates.Resolved == "" {
+		templates.Resolved = templates.Firing
+		if templates.ResolvedText == "" {
+			templates.ResolvedText = templates.FiringText
 		}
+		if templates.ResolvedMessageType == "" {
+			templates.ResolvedMessageType = templates.FiringMessageType
+		}
+	}
+	return templates
+}
 
-		labelsMatch := true
-		if len(computer.LabelMatcher) > 0 {
-			for k, v := range computer.LabelMatcher {
-				if labelValue, ok := alert.Labels[k]; !ok || labelValue != v {
-					labelsMatch = false
-					break
-				}
-			}
+// selectNamedTemplate returns the index of the first named template matching the given alert, or -1 in case the
+// default templates should be used.
+func selectNamedTemplate(configuration config.Templating, alert amtemplate.Alert) int {
+	for index, named := range configuration.Templates {
+		if matches(alert, named.StatusMatcher, named.LabelMatcher, named.AnnotationMatcher) {
+			return index
 		}
+	}
+	return -1
+}
 
-		annotationsMatch := true
-		if len(computer.AnnotationMatcher) > 0 {
-			for k, v := range computer.AnnotationMatcher {
-				if annotationValue, ok := alert.Annotations[k]; !ok || annotationValue != v {
-					annotationsMatch = false
-					break
-				}
-			}
+func CreateExplainingFunc(ctx context.Context, configuration config.Templating) ExplainingFunc {
+	return func(alert amtemplate.Alert, data *amtemplate.Data) Explanation {
+		values := newTemplateData(ctx, configuration, alert, data)
+		return Explanation{
+			Template:       selectTemplate(configuration, alert),
+			ComputedValues: values.ComputedValues,
+			SilenceURL:     values.SilenceURL,
 		}
+	}
+}
+
+// selectTemplate returns the name of the template used to render the given alert, which is either the name of a
+// named template or 'firing' or 'resolved' for the default templates.
+func selectTemplate(configuration config.Templating, alert amtemplate.Alert) string {
+	if index := selectNamedTemplate(configuration, alert); index >= 0 {
+		return configuration.Templates[index].Name
+	}
+	if alert.Status == string(model.AlertResolved) {
+		return "resolved"
+	}
+	return "firing"
+}
+
+func CreateGroupTemplatingFunc(ctx context.Context, configuration config.Templating) (GroupTemplatingFunc, error) {
+	slog.DebugContext(ctx, "Creating group templating function", slog.Any("configuration", configuration.LogValue()))
 
-		if statusMatches && labelsMatch && annotationsMatch {
+	group, err := parseTemplate(ctx, configuration, configuration.Format, "group", configuration.Group)
+	if err != nil {
+		return nil, fmt.Errorf("invalid group template: %w", err)
+	}
+
+	return func(data *amtemplate.Data) (Rendered, error) {
+		rendered, err := render(group, nil, configuration.Format, newGroupTemplateData(ctx, configuration, data))
+		if err != nil {
+			templatingFailureTotal.Inc()
+			slog.ErrorContext(ctx, "Cannot template given group data", slog.Any("error", err))
+			return Rendered{}, err
+		}
+		templatingSuccessTotal.Inc()
+		return rendered, nil
+	}, nil
+}
+
+// parseTemplate parses the given template together with the shared templates of the configured template files.
+// Markdown templates are parsed as text templates, since their output is used as plain-text version as well. Raw HTML
+// in their output is escaped while converting it into HTML instead, see renderMarkdown.
+func parseTemplate(ctx context.Context, configuration config.Templating, format string, name string, text string) (executable, error) {
+	if format == config.FormatMarkdown {
+		parsed, err := parseTextTemplate(ctx, configuration, name, text)
+		if err != nil {
+			return nil, err
+		}
+		return parsed, nil
+	}
+	parsed := template.New(name).Funcs(createTemplateFunctions(ctx))
+	if configuration.Files != "" {
+		if _, err := parsed.ParseGlob(configuration.Files); err != nil {
+			return nil, err
+		}
+	}
+	return parsed.Parse(text)
+}
+
+// parseTextTemplate parses the given plain-text template. In contrast to parseTemplate, its output is not escaped
+// since it is never interpreted as HTML.
+func parseTextTemplate(ctx context.Context, configuration config.Templating, name string, text string) (*texttemplate.Template, error) {
+	parsed := texttemplate.New(name).Funcs(texttemplate.FuncMap(createTemplateFunctions(ctx)))
+	if configuration.Files != "" {
+		if _, err := parsed.ParseGlob(configuration.Files); err != nil {
+			return nil, err
+		}
+	}
+	return parsed.Parse(text)
+}
+
+// render executes the template and converts its output into HTML according to the format of the template. The
+// optional text template replaces the plain-text version.
+func render(tmpl executable, textTemplate *texttemplate.Template, format string, data renderData) (Rendered, error) {
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, data.withMentionStyle(format)); err != nil {
+		return Rendered{}, err
+	}
+	rendered := Rendered{HTML: output.String()}
+	if format == config.FormatMarkdown {
+		rendered = renderMarkdown(output.String())
+	}
+	if textTemplate != nil {
+		var text bytes.Buffer
+		if err := textTemplate.Execute(&text, data.withMentionStyle(mentionStyleText)); err != nil {
+			return Rendered{}, err
+		}
+		rendered.Text = text.String()
+	}
+	rendered.Mentions = data.collected()
+	return rendered, nil
+}
+
+// renderMarkdown converts the given Markdown into HTML and keeps the Markdown itself as plain-text version, since it
+// is readable by clients that do not support HTML. Raw HTML is not allowed, since label and annotation values are not
+// escaped by Markdown templates.
+func renderMarkdown(markdown string) Rendered {
+	content := mautrixformat.RenderMarkdown(markdown, true, false)
+	html := content.FormattedBody
+	if html == "" {
+		html = template.HTMLEscapeString(content.Body)
+	}
+	return Rendered{HTML: html, Text: markdown}
+}
+
+func newTemplateData(ctx context.Context, configuration config.Templating, alert amtemplate.Alert, data *amtemplate.Data) templateData {
+	externalUrl := maybeMapValue(data.ExternalURL, configuration.ExternalURLMapping)
+	slog.DebugContext(ctx, "ExternalURL mapped",
+		slog.String("original-url", data.ExternalURL),
+		slog.String("mapped-url", externalUrl))
+
+	generatorUrl := maybeMapValue(alert.GeneratorURL, configuration.GeneratorURLMapping)
+	slog.DebugContext(ctx, "GeneratorURL mapped",
+		slog.String("original-url", alert.GeneratorURL),
+		slog.String("mapped-url", generatorUrl))
+
+	silenceUrl := silenceURL(alert, externalUrl)
+	slog.DebugContext(ctx, "Silence URL computed", slog.String("silence-url", silenceUrl))
+
+	values := computeValues(alert, configuration.ComputedValues)
+	slog.DebugContext(ctx, "Values computed", slog.Any("values", values))
+
+	return templateData{
+		Alert:             alert,
+		GroupLabels:       data.GroupLabels,
+		CommonLabels:      data.CommonLabels,
+		CommonAnnotations: data.CommonAnnotations,
+		SilenceURL:        silenceUrl,
+		ExternalURL:       externalUrl,
+		GeneratorURL:      generatorUrl,
+		ComputedValues:    values,
+		mentioner:         newMentioner(configuration, alert.Labels),
+	}
+}
+
+func newGroupTemplateData(ctx context.Context, configuration config.Templating, data *amtemplate.Data) groupTemplateData {
+	externalUrl := maybeMapValue(data.ExternalURL, configuration.ExternalURLMapping)
+	slog.DebugContext(ctx, "ExternalURL mapped",
+		slog.String("original-url", data.ExternalURL),
+		slog.String("mapped-url", externalUrl))
+
+	silenceUrl := labelsSilenceURL(data.CommonLabels, externalUrl)
+	slog.DebugContext(ctx, "Silence URL computed", slog.String("silence-url", silenceUrl))
+
+	return groupTemplateData{
+		Receiver:          data.Receiver,
+		Status:            data.Status,
+		Alerts:            data.Alerts,
+		GroupLabels:       data.GroupLabels,
+		CommonLabels:      data.CommonLabels,
+		CommonAnnotations: data.CommonAnnotations,
+		FiringCount:       len(data.Alerts.Firing()),
+		ResolvedCount:     len(data.Alerts.Resolved()),
+		SilenceURL:        silenceUrl,
+		ExternalURL:       externalUrl,
+		mentioner:         newMentioner(configuration, alertLabels(data.Alerts)...),
+	}
+}
+
+func alertLabels(alerts amtemplate.Alerts) []amtemplate.KV {
+	var labels []amtemplate.KV
+	for _, alert := range alerts {
+		labels = append(labels, alert.Labels)
+	}
+	return labels
+}
+
+// createTemplateFunctions returns the functions of Alertmanager templates, additional helpers, and the functions of
+// this receiver which take precedence in case of conflicts.
+func createTemplateFunctions(ctx context.Context) template.FuncMap {
+	functions := template.FuncMap(maps.Clone(amtemplate.DefaultFuncs))
+	maps.Copy(functions, helperFunctions())
+	maps.Copy(functions, template.FuncMap{
+		"ToUpper": strings.ToUpper,
+		"ToLower": strings.ToLower,
+		"Replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"RegexReplace": func(pattern, replacement, s string) string {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				slog.ErrorContext(ctx, "Invalid regex pattern", slog.String("pattern", pattern), slog.Any("error", err))
+				return s
+			}
+			return re.ReplaceAllString(s, replacement)
+		},
+	})
+	return functions
+}
+
+func computeValues(alert amtemplate.Alert, values []config.ComputedValue) map[string]string {
+	computedValues := make(map[string]string)
+	for _, computer := range values {
+		if len(computer.Values) == 0 {
+			continue
+		}
+		if matches(alert, computer.StatusMatcher, computer.LabelMatcher, computer.AnnotationMatcher) {
 			maps.Copy(computedValues, computer.Values)
 		}
 	}
@@ -157,6 +440,25 @@ func computeValues(alert amtemplate.Alert, values []config.ComputedValue) map[st
 	return computedValues
 }
 
+// matches checks whether the alert has the given status and all given labels and annotations. Empty matchers match
+// all alerts.
+func matches(alert amtemplate.Alert, status string, labels config.KeyValue, annotations config.KeyValue) bool {
+	if status != "" && alert.Status != status {
+		return false
+	}
+	for k, v := range labels {
+		if labelValue, ok := alert.Labels[k]; !ok || labelValue != v {
+			return false
+		}
+	}
+	for k, v := range annotations {
+		if annotationValue, ok := alert.Annotations[k]; !ok || annotationValue != v {
+			return false
+		}
+	}
+	return true
+}
+
 func maybeMapValue(original string, mapping map[string]string) string {
 	if replacement, ok := mapping[original]; ok {
 		return replacement
@@ -165,12 +467,16 @@ func maybeMapValue(original string, mapping map[string]string) string {
 }
 
 func silenceURL(alert amtemplate.Alert, externalURL string) string {
+	return labelsSilenceURL(alert.Labels, externalURL)
+}
+
+func labelsSilenceURL(labels amtemplate.KV, externalURL string) string {
 	if externalURL == "" {
 		return ""
 	}
 	return fmt.Sprintf(`%s/#/silences/new%s`,
 		strings.TrimSuffix(externalURL, "/"),
-		silenceFilter(alert.Labels))
+		silenceFilter(labels))
 }
 
 func silenceFilter(labels amtemplate.KV) string {
@@ -178,8 +484,8 @@ func silenceFilter(labels amtemplate.KV) string {
 		return ""
 	}
 	var filters []string
-	for key, value := range labels {
-		filters = append(filters, fmt.Sprintf(`%s="%s"`, key, value))
+	for _, matcher := range LabelMatchers(labels) {
+		filters = append(filters, fmt.Sprintf(`%s="%s"`, matcher.Name, matcher.Value))
 	}
 	sort.SliceStable(filters, func(i, j int) bool {
 		return filters[i] < filters[j]
@@ -7,6 +7,8 @@ package alertmanager
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/metio/matrix-alertmanager-receiver/internal/config"
@@ -131,10 +133,11 @@ func TestReplace(t *testing.T) {
 	}
 	for name, testCase := range testCases {
 		t.Run(name, func(t *testing.T) {
-			templatingFunc := CreateTemplatingFunc(context.Background(), config.Templating{Firing: testCase.templateStr})
+			templatingFunc, err := CreateTemplatingFunc(context.Background(), config.Templating{Firing: testCase.templateStr})
+			assert.NoError(t, err)
 			result, err := templatingFunc(amtemplate.Alert{}, &amtemplate.Data{})
 			assert.NoError(t, err)
-			assert.Equal(t, testCase.expected, result)
+			assert.Equal(t, testCase.expected, result.HTML)
 		})
 	}
 }
@@ -159,10 +162,11 @@ func TestRegexReplace(t *testing.T) {
 	}
 	for name, testCase := range testCases {
 		t.Run(name, func(t *testing.T) {
-			templatingFunc := CreateTemplatingFunc(context.Background(), config.Templating{Firing: testCase.templateStr})
+			templatingFunc, err := CreateTemplatingFunc(context.Background(), config.Templating{Firing: testCase.templateStr})
+			assert.NoError(t, err)
 			result, err := templatingFunc(amtemplate.Alert{}, &amtemplate.Data{})
 			assert.NoError(t, err)
-			assert.Equal(t, testCase.expected, result)
+			assert.Equal(t, testCase.expected, result.HTML)
 		})
 	}
 }
@@ -332,3 +336,327 @@ func TestComputedValues(t *testing.T) {
 		})
 	}
 }
+
+func TestGroupTemplating(t *testing.T) {
+	testCases := map[string]struct {
+		templateStr string
+		data        *amtemplate.Data
+		expected    string
+	}{
+		"counts": {
+			templateStr: `{{ .FiringCount }} firing, {{ .ResolvedCount }} resolved`,
+			data: &amtemplate.Data{
+				Alerts: amtemplate.Alerts{
+					{Status: "firing"},
+					{Status: "firing"},
+					{Status: "resolved"},
+				},
+			},
+			expected: "2 firing, 1 resolved",
+		},
+		"firing-alerts": {
+			templateStr: `{{ range .Alerts.Firing }}{{ .Labels.alertname }} {{ end }}`,
+			data: &amtemplate.Data{
+				Alerts: amtemplate.Alerts{
+					{Status: "firing", Labels: amtemplate.KV{"alertname": "first"}},
+					{Status: "resolved", Labels: amtemplate.KV{"alertname": "second"}},
+					{Status: "firing", Labels: amtemplate.KV{"alertname": "third"}},
+				},
+			},
+			expected: "first third ",
+		},
+		"group-labels": {
+			templateStr: `{{ .Status | ToUpper }} {{ .GroupLabels.alertname }}`,
+			data: &amtemplate.Data{
+				Status:      "firing",
+				GroupLabels: amtemplate.KV{"alertname": "instance_down"},
+			},
+			expected: "FIRING instance_down",
+		},
+		"silence-url": {
+			templateStr: `{{ .SilenceURL }}`,
+			data: &amtemplate.Data{
+				ExternalURL:  "example.com",
+				CommonLabels: amtemplate.KV{"something": "value"},
+			},
+			expected: "example.com/#/silences/new?filter=%7Bsomething%3D%22value%22%7D",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			templatingFunc, err := CreateGroupTemplatingFunc(context.Background(), config.Templating{Group: testCase.templateStr})
+			assert.NoError(t, err)
+			result, err := templatingFunc(testCase.data)
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, result.HTML)
+		})
+	}
+}
+
+func TestCreateTemplatingFunc_InvalidTemplate(t *testing.T) {
+	_, err := CreateTemplatingFunc(context.Background(), config.Templating{Firing: "{{ .Alert.Status "})
+	assert.Error(t, err)
+	_, err = CreateGroupTemplatingFunc(context.Background(), config.Templating{Group: "{{ end }}"})
+	assert.Error(t, err)
+}
+
+func TestTemplateFiles(t *testing.T) {
+	directory := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "partials.tmpl"),
+		[]byte(`{{ define "name" }}<b>{{ .Alert.Labels.alertname }}</b>{{ end }}`), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, "links.tmpl"),
+		[]byte(`{{ define "silence" }}<a href="{{ .SilenceURL }}">Silence</a>{{ end }}`), 0o600))
+	configuration := config.Templating{
+		Firing:   `{{ template "name" . }} is firing {{ template "silence" . }}`,
+		Resolved: `{{ template "name" . }} is resolved`,
+		Group:    `{{ range .Alerts }}{{ .Labels.alertname }} {{ end }}`,
+		Files:    filepath.Join(directory, "*.tmpl"),
+	}
+	templatingFunc, err := CreateTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+	data := &amtemplate.Data{ExternalURL: "https://alertmanager.example.com"}
+
+	firing, err := templatingFunc(amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down"}}, data)
+	assert.NoError(t, err)
+	assert.Equal(t, `<b>down</b> is firing <a href="https://alertmanager.example.com/#/silences/new?filter=%7Balertname%3D%22down%22%7D">Silence</a>`, firing.HTML)
+
+	resolved, err := templatingFunc(amtemplate.Alert{Status: "resolved", Labels: amtemplate.KV{"alertname": "down"}}, data)
+	assert.NoError(t, err)
+	assert.Equal(t, `<b>down</b> is resolved`, resolved.HTML)
+
+	_, err = CreateGroupTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+
+	configuration.Files = filepath.Join(directory, "*.missing")
+	_, err = CreateTemplatingFunc(context.Background(), configuration)
+	assert.Error(t, err)
+}
+
+func TestNamedTemplates(t *testing.T) {
+	configuration := config.Templating{
+		Firing:   `default firing {{ .Alert.Labels.alertname }}`,
+		Resolved: `default resolved {{ .Alert.Labels.alertname }}`,
+		Templates: []config.NamedTemplate{
+			{
+				Name:         "critical",
+				LabelMatcher: config.KeyValue{"severity": "critical"},
+				Firing:       `@room {{ .Alert.Labels.alertname }}`,
+				Resolved:     `critical resolved {{ .Alert.Labels.alertname }}`,
+			},
+			{
+				Name:          "info",
+				LabelMatcher:  config.KeyValue{"severity": "info"},
+				StatusMatcher: "firing",
+				Firing:        `{{ .Alert.Labels.alertname }}`,
+			},
+			{
+				Name:              "runbook",
+				AnnotationMatcher: config.KeyValue{"runbook": "https://runbook.example.com"},
+				Firing:            `{{ .Alert.Labels.alertname }} {{ .Alert.Annotations.runbook }}`,
+			},
+		},
+	}
+	testCases := map[string]struct {
+		alert            amtemplate.Alert
+		expected         string
+		expectedTemplate string
+	}{
+		"critical-firing": {
+			alert:            amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "severity": "critical"}},
+			expected:         "@room down",
+			expectedTemplate: "critical",
+		},
+		"critical-resolved": {
+			alert:            amtemplate.Alert{Status: "resolved", Labels: amtemplate.KV{"alertname": "down", "severity": "critical"}},
+			expected:         "critical resolved down",
+			expectedTemplate: "critical",
+		},
+		"info-firing": {
+			alert:            amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "severity": "info"}},
+			expected:         "down",
+			expectedTemplate: "info",
+		},
+		"info-resolved-uses-default": {
+			alert:            amtemplate.Alert{Status: "resolved", Labels: amtemplate.KV{"alertname": "down", "severity": "info"}},
+			expected:         "default resolved down",
+			expectedTemplate: "resolved",
+		},
+		"annotation-match-uses-firing-for-resolved": {
+			alert:            amtemplate.Alert{Status: "resolved", Labels: amtemplate.KV{"alertname": "down"}, Annotations: amtemplate.KV{"runbook": "https://runbook.example.com"}},
+			expected:         "down https://runbook.example.com",
+			expectedTemplate: "runbook",
+		},
+		"no-match": {
+			alert:            amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "severity": "warning"}},
+			expected:         "default firing down",
+			expectedTemplate: "firing",
+		},
+	}
+	templatingFunc, err := CreateTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+	explainingFunc := CreateExplainingFunc(context.Background(), configuration)
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			output, err := templatingFunc(testCase.alert, &amtemplate.Data{})
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, output.HTML)
+			assert.Equal(t, testCase.expectedTemplate, explainingFunc(testCase.alert, &amtemplate.Data{}).Template)
+		})
+	}
+}
+
+func TestMarkdownFormat(t *testing.T) {
+	configuration := config.Templating{
+		Firing: "**{{ .Alert.Labels.alertname }}** is firing\n\n- instance: `{{ .Alert.Labels.instance }}`",
+		Group:  "{{ .FiringCount }} alerts firing",
+		Format: config.FormatMarkdown,
+		Templates: []config.NamedTemplate{
+			{
+				Name:         "html",
+				LabelMatcher: config.KeyValue{"format": "html"},
+				Format:       config.FormatHTML,
+				Firing:       "<b>{{ .Alert.Labels.alertname }}</b>",
+			},
+		},
+	}
+	templatingFunc, err := CreateTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+
+	markdown, err := templatingFunc(amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "instance": "db-1"}}, &amtemplate.Data{})
+	assert.NoError(t, err)
+	assert.Equal(t, Rendered{
+		HTML: "<p><strong>down</strong> is firing</p>\n<ul>\n<li>instance: <code>db-1</code></li>\n</ul>",
+		Text: "**down** is firing\n\n- instance: `db-1`",
+	}, markdown)
+
+	html, err := templatingFunc(amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "format": "html"}}, &amtemplate.Data{})
+	assert.NoError(t, err)
+	assert.Equal(t, Rendered{HTML: "<b>down</b>"}, html)
+
+	groupTemplatingFunc, err := CreateGroupTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+	group, err := groupTemplatingFunc(&amtemplate.Data{Alerts: amtemplate.Alerts{{Status: "firing"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, Rendered{HTML: "1 alerts firing", Text: "1 alerts firing"}, group)
+}
+
+func TestMarkdownFormat_Escaping(t *testing.T) {
+	configuration := config.Templating{
+		Firing: "**{{ .Alert.Labels.alertname }}** is firing on {{ .Alert.Labels.instance }}",
+		Format: config.FormatMarkdown,
+	}
+	templatingFunc, err := CreateTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+
+	markdown, err := templatingFunc(amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "Bob's disk", "instance": "a & b"}}, &amtemplate.Data{})
+	assert.NoError(t, err)
+	assert.Equal(t, Rendered{
+		HTML: "<strong>Bob's disk</strong> is firing on a &amp; b",
+		Text: "**Bob's disk** is firing on a & b",
+	}, markdown)
+
+	markdown, err = templatingFunc(amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "<b>disk</b>", "instance": `<a href="https://evil">db-1</a>`}}, &amtemplate.Data{})
+	assert.NoError(t, err)
+	assert.Equal(t, Rendered{
+		HTML: "<strong>&lt;b&gt;disk&lt;/b&gt;</strong> is firing on &lt;a href=&quot;https://evil&quot;&gt;db-1&lt;/a&gt;",
+		Text: "**<b>disk</b>** is firing on <a href=\"https://evil\">db-1</a>",
+	}, markdown)
+}
+
+func TestTextTemplates(t *testing.T) {
+	configuration := config.Templating{
+		Firing:     `<strong>{{ .Alert.Labels.alertname }}</strong> is firing`,
+		FiringText: `{{ .Alert.Labels.alertname }} is firing on <{{ .Alert.Labels.instance }}>`,
+		Templates: []config.NamedTemplate{
+			{
+				Name:         "critical",
+				LabelMatcher: config.KeyValue{"severity": "critical"},
+				Firing:       `@room <strong>{{ .Alert.Labels.alertname }}</strong>`,
+				Resolved:     `<strong>{{ .Alert.Labels.alertname }}</strong> is resolved`,
+				FiringText:   `@room {{ .Alert.Labels.alertname }}`,
+			},
+		},
+	}
+	testCases := map[string]struct {
+		alert    amtemplate.Alert
+		expected Rendered
+	}{
+		"firing": {
+			alert:    amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "instance": "db-1"}},
+			expected: Rendered{HTML: "<strong>down</strong> is firing", Text: "down is firing on <db-1>"},
+		},
+		"resolved-uses-firing-text": {
+			alert:    amtemplate.Alert{Status: "resolved", Labels: amtemplate.KV{"alertname": "down", "instance": "db-1"}},
+			expected: Rendered{HTML: "<strong>down</strong> is firing", Text: "down is firing on <db-1>"},
+		},
+		"named-firing": {
+			alert:    amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "severity": "critical"}},
+			expected: Rendered{HTML: "@room <strong>down</strong>", Text: "@room down"},
+		},
+		"named-resolved-without-text": {
+			alert:    amtemplate.Alert{Status: "resolved", Labels: amtemplate.KV{"alertname": "down", "severity": "critical"}},
+			expected: Rendered{HTML: "<strong>down</strong> is resolved"},
+		},
+	}
+	templatingFunc, err := CreateTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			output, err := templatingFunc(testCase.alert, &amtemplate.Data{})
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, output)
+		})
+	}
+}
+
+func TestMessageTypes(t *testing.T) {
+	configuration := config.Templating{
+		Firing:              `{{ .Alert.Labels.alertname }} is firing`,
+		Resolved:            `{{ .Alert.Labels.alertname }} is resolved`,
+		FiringMessageType:   config.MessageTypeText,
+		ResolvedMessageType: config.MessageTypeNotice,
+		Templates: []config.NamedTemplate{
+			{
+				Name:              "info",
+				LabelMatcher:      config.KeyValue{"severity": "info"},
+				Firing:            `{{ .Alert.Labels.alertname }}`,
+				FiringMessageType: config.MessageTypeNotice,
+			},
+			{
+				Name:         "room-default",
+				LabelMatcher: config.KeyValue{"severity": "warning"},
+				Firing:       `{{ .Alert.Labels.alertname }}`,
+			},
+		},
+	}
+	testCases := map[string]struct {
+		alert    amtemplate.Alert
+		expected string
+	}{
+		"default-decided-by-room": {
+			alert:    amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down"}},
+			expected: "",
+		},
+		"named": {
+			alert:    amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "severity": "info"}},
+			expected: config.MessageTypeNotice,
+		},
+		"named-resolved-uses-firing": {
+			alert:    amtemplate.Alert{Status: "resolved", Labels: amtemplate.KV{"alertname": "down", "severity": "info"}},
+			expected: config.MessageTypeNotice,
+		},
+		"named-without-message-type": {
+			alert:    amtemplate.Alert{Status: "firing", Labels: amtemplate.KV{"alertname": "down", "severity": "warning"}},
+			expected: "",
+		},
+	}
+	templatingFunc, err := CreateTemplatingFunc(context.Background(), configuration)
+	assert.NoError(t, err)
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			output, err := templatingFunc(testCase.alert, &amtemplate.Data{})
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.expected, output.MessageType)
+		})
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// CheckConfiguration parses the given configuration file strictly and returns every problem found in it. In contrast
+// to ParseConfiguration, unknown fields and duplicate keys are reported as well. The returned configuration is nil
+// in case the file could not be parsed at all.
+func CheckConfiguration(configPath string) (*Configuration, []Problem) {
+	file, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, []Problem{{Message: fmt.Sprintf("could not read configuration file: %v", err)}}
+	}
+	content, err := yaml.YAMLToJSONStrict(replaceEnvVariables(file))
+	if err != nil {
+		return nil, []Problem{{Message: fmt.Sprintf("invalid YAML: %v", err)}}
+	}
+
+	var problems []Problem
+	var raw any
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, []Problem{{Message: fmt.Sprintf("invalid YAML: %v", err)}}
+	}
+	problems = append(problems, unknownFields(raw, reflect.TypeFor[Configuration](), "")...)
+
+	var configuration Configuration
+	if err := json.Unmarshal(content, &configuration); err != nil {
+		var typeError *json.UnmarshalTypeError
+		if errors.As(err, &typeError) {
+			problems = append(problems, Problem{
+				Path:    typeError.Field,
+				Message: fmt.Sprintf("cannot use %s as %s", typeError.Value, typeError.Type),
+			})
+		} else {
+			problems = append(problems, Problem{Message: err.Error()})
+		}
+	}
+
+	problems = append(problems, validate(&configuration)...)
+	return &configuration, problems
+}
+
+// unknownFields compares the decoded YAML document with the fields of the given type and reports all keys which
+// do not correspond to any field.
+func unknownFields(value any, valueType reflect.Type, path string) []Problem {
+	var problems []Problem
+	switch valueType.Kind() {
+	case reflect.Pointer:
+		return unknownFields(value, valueType.Elem(), path)
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		for index := range valueType.NumField() {
+			field := valueType.Field(index)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name != "" && name != "-" {
+				fields[name] = field.Type
+			}
+		}
+		for _, key := range slices.Sorted(maps.Keys(object)) {
+			fieldPath := joinPath(path, key)
+			fieldType, known := fields[key]
+			if !known {
+				problems = append(problems, Problem{Path: fieldPath, Message: "unknown field"})
+				continue
+			}
+			problems = append(problems, unknownFields(object[key], fieldType, fieldPath)...)
+		}
+	case reflect.Slice:
+		list, ok := value.([]any)
+		if !ok {
+			return nil
+		}
+		for index, element := range list {
+			problems = append(problems, unknownFields(element, valueType.Elem(), fmt.Sprintf("%s[%d]", path, index))...)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		for _, key := range slices.Sorted(maps.Keys(object)) {
+			problems = append(problems, unknownFields(object[key], valueType.Elem(), joinPath(path, key))...)
+		}
+	default:
+		// scalar values cannot contain unknown fields
+	}
+	return problems
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,112 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfiguration(t *testing.T) {
+	testCases := map[string]struct {
+		configuration string
+		expected      []Problem
+	}{
+		"valid": {
+			configuration: `
+http:
+  port: 12345
+matrix:
+  homeserver-url: https://matrix.example.com
+  user-id: "@user:matrix.example.com"
+  access-token: secret
+  room-mapping:
+    simple: "!abc:matrix.example.com"
+templating:
+  firing-template: something broke
+`,
+		},
+		"unknown-fields": {
+			configuration: `
+http:
+  port: 12345
+  prot: 12345
+matrix:
+  homeserver-url: https://matrix.example.com
+  user-id: "@user:matrix.example.com"
+  access-token: secret
+templating:
+  firing-template: something broke
+  computed-values:
+    - values:
+        color: red
+      when-matching-label:
+        severity: critical
+`,
+			expected: []Problem{
+				{Path: "http.prot", Message: "unknown field"},
+				{Path: "templating.computed-values[0].when-matching-label", Message: "unknown field"},
+			},
+		},
+		"wrong-type": {
+			configuration: `
+http:
+  port: 12345
+matrix:
+  homeserver-url: https://matrix.example.com
+  user-id: "@user:matrix.example.com"
+  access-token: secret
+templating:
+  firing-template: something broke
+queue:
+  directory: /tmp/queue
+  workers: many
+`,
+			expected: []Problem{
+				{Path: "queue.workers", Message: "cannot use string as int"},
+			},
+		},
+		"validation-errors": {
+			configuration: `
+http:
+  port: 0
+matrix:
+  homeserver-url: https://matrix.example.com
+  user-id: "@user:matrix.example.com"
+  access-token: secret
+  room-mapping:
+    simple: something
+templating:
+  firing-template: something broke
+`,
+			expected: []Problem{
+				{Path: "http.port", Message: "invalid HTTP port 0 specified"},
+				{Path: "matrix.room-mapping.simple", Message: `room "something" is neither a room ID nor a room alias`},
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			assert.NoError(t, os.WriteFile(configPath, []byte(testCase.configuration), 0o600))
+			configuration, problems := CheckConfiguration(configPath)
+			assert.NotNil(t, configuration)
+			assert.Equal(t, testCase.expected, problems)
+		})
+	}
+}
+
+func TestCheckConfiguration_DuplicateKeys(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte("http:\n  port: 1\n  port: 2\n"), 0o600))
+	configuration, problems := CheckConfiguration(configPath)
+	assert.Nil(t, configuration)
+	assert.Len(t, problems, 1)
+	assert.Contains(t, problems[0].Message, `key "port" already set in map`)
+}
@@ -6,13 +6,23 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"log/slog"
+	"net/url"
+
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/common/model"
 )
 
 type Configuration struct {
-	HTTPServer HTTPServer `json:"http"`
-	Matrix     Matrix     `json:"matrix"`
-	Templating Templating `json:"templating"`
+	HTTPServer   HTTPServer   `json:"http"`
+	Matrix       Matrix       `json:"matrix"`
+	Templating   Templating   `json:"templating"`
+	Routes       []Route      `json:"routes"`
+	Queue        Queue        `json:"queue"`
+	State        State        `json:"state"`
+	Alertmanager Alertmanager `json:"alertmanager"`
 }
 
 func (c *Configuration) LogValue() slog.Value {
@@ -20,17 +30,25 @@ func (c *Configuration) LogValue() slog.Value {
 		slog.Any("http", c.HTTPServer.LogValue()),
 		slog.Any("matrix", c.Matrix.LogValue()),
 		slog.Any("templating", c.Templating.LogValue()),
+		slog.Any("routes", c.Routes),
+		slog.Any("queue", c.Queue.LogValue()),
+		slog.Any("state", c.State.LogValue()),
+		slog.Any("alertmanager", c.Alertmanager.LogValue()),
 	)
 }
 
 type HTTPServer struct {
-	Address          string `json:"address"`
-	Port             int    `json:"port"`
-	AlertsPathPrefix string `json:"alerts-path-prefix"`
-	MetricsPath      string `json:"metrics-path"`
-	MetricsEnabled   bool   `json:"metrics-enabled"`
-	BasicUsername    string `json:"basic-username"`
-	BasicPassword    string `json:"basic-password"`
+	Address           string `json:"address"`
+	Port              int    `json:"port"`
+	AlertsPathPrefix  string `json:"alerts-path-prefix"`
+	MetricsPath       string `json:"metrics-path"`
+	MetricsEnabled    bool   `json:"metrics-enabled"`
+	PreviewPathPrefix string `json:"preview-path-prefix"`
+	PreviewEnabled    bool   `json:"preview-enabled"`
+	BasicUsername     string `json:"basic-username"`
+	BasicPassword     string `json:"basic-password"`
+	// BasicPasswordFile is read while parsing the configuration and replaces BasicPassword.
+	BasicPasswordFile string `json:"basic-password-file"`
 }
 
 func (h *HTTPServer) LogValue() slog.Value {
@@ -40,39 +58,322 @@ func (h *HTTPServer) LogValue() slog.Value {
 		slog.String("alerts-path", h.AlertsPathPrefix),
 		slog.String("metrics-path", h.MetricsPath),
 		slog.Bool("metrics-enabled", h.MetricsEnabled),
+		slog.String("preview-path", h.PreviewPathPrefix),
+		slog.Bool("preview-enabled", h.PreviewEnabled),
 		slog.String("basic-username", h.BasicUsername),
+		slog.String("basic-password-file", h.BasicPasswordFile),
 	)
 }
 
+// Route selects rooms for alerts whose labels satisfy all matchers. Matchers use the Alertmanager syntax, e.g.
+// 'severity=~"critical|warning"'.
+type Route struct {
+	Matchers []string `json:"matchers"`
+	Rooms    []string `json:"rooms"`
+	Continue bool     `json:"continue"`
+}
+
+func ParseMatchers(matchers []string) (labels.Matchers, error) {
+	var parsed labels.Matchers
+	for _, matcher := range matchers {
+		m, err := labels.ParseMatcher(matcher)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %w", matcher, err)
+		}
+		parsed = append(parsed, m)
+	}
+	return parsed, nil
+}
+
 type Matrix struct {
-	HomeServerURL string            `json:"homeserver-url"`
-	UserID        string            `json:"user-id"`
-	AccessToken   string            `json:"access-token"`
-	Proxy         string            `json:"proxy"`
-	RoomMapping   map[string]string `json:"room-mapping"`
+	HomeServerURL string `json:"homeserver-url"`
+	UserID        string `json:"user-id"`
+	AccessToken   string `json:"access-token"`
+	// AccessTokenFile is read while parsing the configuration and replaces AccessToken.
+	AccessTokenFile string              `json:"access-token-file"`
+	Proxy           string              `json:"proxy"`
+	RoomMapping     map[string]RoomList `json:"room-mapping"`
+	AliasCacheTTL   model.Duration      `json:"alias-cache-ttl"`
+	UpdateMode      string              `json:"update-mode"`
+	ThreadKey       string              `json:"thread-key"`
+	// UpdateRetention is how long later messages of an alert refer to its first message when editing or threading.
+	UpdateRetention model.Duration  `json:"update-retention"`
+	Encryption      Encryption      `json:"encryption"`
+	Silencing       Silencing       `json:"silencing"`
+	Commands        Commands        `json:"commands"`
+	Acknowledgement Acknowledgement `json:"acknowledgement"`
+	Escalation      Escalation      `json:"escalation"`
+	// NotificationRetention is how long users can interact with a notification after it was sent.
+	NotificationRetention model.Duration `json:"notification-retention"`
+}
+
+// Encryption configures end-to-end encryption of messages sent into encrypted rooms. It requires a binary built with
+// the goolm build tag.
+type Encryption struct {
+	// Database is the SQLite database which stores the encryption keys and the state of all rooms.
+	Database  string `json:"database"`
+	PickleKey string `json:"pickle-key"`
+	// PickleKeyFile is read while parsing the configuration and replaces PickleKey.
+	PickleKeyFile string `json:"pickle-key-file"`
+}
+
+func (e *Encryption) Enabled() bool {
+	return e.Database != ""
+}
+
+func (e *Encryption) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("database", e.Database),
+		slog.String("pickle-key-file", e.PickleKeyFile),
+	)
+}
+
+// Silencing creates silences in Alertmanager for the alerts of a notification once a user reacts to it with the
+// configured reaction.
+type Silencing struct {
+	Reaction string         `json:"reaction"`
+	Duration model.Duration `json:"duration"`
+	// PowerLevel is the power level users need in a room to silence alerts. Defaults to DefaultPowerLevel.
+	PowerLevel *int `json:"power-level"`
+}
+
+func (s *Silencing) Enabled() bool {
+	return s.Reaction != ""
+}
+
+// RequiredPowerLevel returns the power level users need in a room to silence alerts.
+func (s *Silencing) RequiredPowerLevel() int {
+	if s.PowerLevel == nil {
+		return DefaultPowerLevel
+	}
+	return *s.PowerLevel
+}
+
+func (s *Silencing) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("reaction", s.Reaction),
+		slog.String("duration", s.Duration.String()),
+		slog.Int("power-level", s.RequiredPowerLevel()),
+	)
+}
+
+// Commands lets users manage alerts and silences with commands like '!alerts' sent into the rooms this receiver has
+// joined.
+type Commands struct {
+	Enabled bool `json:"enabled"`
+	// PowerLevel is the power level users need in a room to run commands. Defaults to DefaultPowerLevel.
+	PowerLevel *int `json:"power-level"`
+}
+
+// RequiredPowerLevel returns the power level users need in a room to run commands.
+func (c *Commands) RequiredPowerLevel() int {
+	if c.PowerLevel == nil {
+		return DefaultPowerLevel
+	}
+	return *c.PowerLevel
+}
+
+func (c *Commands) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Bool("enabled", c.Enabled),
+		slog.Int("power-level", c.RequiredPowerLevel()),
+	)
+}
+
+// Acknowledgement lets users acknowledge alerts by reacting to their notification with Reaction or replying to it
+// with Reply. Repeats decides what happens with repeated notifications of acknowledged alerts.
+type Acknowledgement struct {
+	Enabled  bool   `json:"enabled"`
+	Reaction string `json:"reaction"`
+	Reply    string `json:"reply"`
+	Repeats  string `json:"repeats"`
+	// PowerLevel is the power level users need in a room to acknowledge alerts. Defaults to DefaultPowerLevel.
+	PowerLevel *int `json:"power-level"`
+}
+
+// RequiredPowerLevel returns the power level users need in a room to acknowledge alerts.
+func (a *Acknowledgement) RequiredPowerLevel() int {
+	if a.PowerLevel == nil {
+		return DefaultPowerLevel
+	}
+	return *a.PowerLevel
+}
+
+func (a *Acknowledgement) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Bool("enabled", a.Enabled),
+		slog.String("reaction", a.Reaction),
+		slog.String("reply", a.Reply),
+		slog.String("repeats", a.Repeats),
+		slog.Int("power-level", a.RequiredPowerLevel()),
+	)
+}
+
+const (
+	RepeatsSuppress = "suppress"
+	RepeatsShorten  = "shorten"
+)
+
+// Escalation escalates notifications of firing alerts which are not acknowledged in time. Alerts are escalated with
+// the first policy whose matchers all match their labels. Pending escalations are checked every Interval.
+type Escalation struct {
+	Interval model.Duration     `json:"interval"`
+	Policies []EscalationPolicy `json:"policies"`
+}
+
+func (e *Escalation) Enabled() bool {
+	return len(e.Policies) > 0
+}
+
+func (e *Escalation) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("interval", e.Interval.String()),
+		slog.Any("policies", e.Policies),
+	)
+}
+
+// EscalationPolicy takes its steps one after another for alerts whose labels satisfy all matchers. Matchers use the
+// Alertmanager syntax, e.g. 'severity="critical"'. OnCall is the list of users mentioned in turn by steps without
+// users of their own.
+type EscalationPolicy struct {
+	Matchers []string         `json:"matchers"`
+	OnCall   UserList         `json:"on-call"`
+	Steps    []EscalationStep `json:"steps"`
+}
+
+// EscalationStep takes an action once an alert is unacknowledged for longer than After.
+type EscalationStep struct {
+	After  model.Duration `json:"after"`
+	Action string         `json:"action"`
+	Users  UserList       `json:"users"`
+}
+
+const (
+	EscalationActionRoomMention   = "room-mention"
+	EscalationActionMention       = "mention"
+	EscalationActionDirectMessage = "direct-message"
+)
+
+// Interactive returns whether users can interact with this receiver, which requires receiving events from the
+// homeserver.
+func (m *Matrix) Interactive() bool {
+	return m.Silencing.Enabled() || m.Commands.Enabled || m.Acknowledgement.Enabled
+}
+
+// RoomList is a list of rooms which can be written as a single string in case it contains only one room.
+type RoomList []string
+
+func (r *RoomList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*r = RoomList{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*r = multiple
+	return nil
+}
+
+// UserList is a list of Matrix user IDs which can be written as a single string in case it contains only one user.
+type UserList []string
+
+func (u *UserList) UnmarshalJSON(data []byte) error {
+	return (*RoomList)(u).UnmarshalJSON(data)
 }
 
 func (m *Matrix) LogValue() slog.Value {
 	return slog.GroupValue(
 		slog.String("homeserver-url", m.HomeServerURL),
 		slog.String("user-id", m.UserID),
+		slog.String("access-token-file", m.AccessTokenFile),
 		slog.String("proxy", m.Proxy),
 		slog.Any("room-mapping", m.RoomMapping),
+		slog.String("alias-cache-ttl", m.AliasCacheTTL.String()),
+		slog.String("update-mode", m.UpdateMode),
+		slog.String("thread-key", m.ThreadKey),
+		slog.String("update-retention", m.UpdateRetention.String()),
+		slog.Any("encryption", m.Encryption.LogValue()),
+		slog.Any("silencing", m.Silencing.LogValue()),
+		slog.Any("commands", m.Commands.LogValue()),
+		slog.Any("acknowledgement", m.Acknowledgement.LogValue()),
+		slog.Any("escalation", m.Escalation.LogValue()),
+		slog.String("notification-retention", m.NotificationRetention.String()),
 	)
 }
 
+const (
+	UpdateModeNew    = "new"
+	UpdateModeEdit   = "edit"
+	UpdateModeThread = "thread"
+)
+
+const (
+	ThreadKeyFingerprint = "fingerprint"
+	ThreadKeyGroupKey    = "group-key"
+)
+
+// DefaultPowerLevel is the power level of moderators, which users need by default to interact with alerts.
+const DefaultPowerLevel = 50
+
 type Templating struct {
-	ExternalURLMapping  KeyValue        `json:"external-url-mapping"`
-	GeneratorURLMapping KeyValue        `json:"generator-url-mapping"`
-	ComputedValues      []ComputedValue `json:"computed-values"`
-	Firing              string          `json:"firing-template"`
-	Resolved            string          `json:"resolved-template"`
+	ExternalURLMapping   KeyValue        `json:"external-url-mapping"`
+	GeneratorURLMapping  KeyValue        `json:"generator-url-mapping"`
+	ComputedValues       []ComputedValue `json:"computed-values"`
+	Firing               string          `json:"firing-template"`
+	Resolved             string          `json:"resolved-template"`
+	Group                string          `json:"group-template"`
+	NotificationMode     string          `json:"notification-mode"`
+	RoomNotificationMode KeyValue        `json:"room-notification-mode"`
+	Format               string          `json:"format"`
+	MessageType          string          `json:"message-type"`
+	RoomMessageType      KeyValue        `json:"room-message-type"`
+	// Mentions maps label names to label values to the Matrix users mentioned for alerts with that label value.
+	Mentions map[string]map[string]UserList `json:"mentions"`
+	// FiringMessageType and ResolvedMessageType override MessageType for the firing and resolved templates, while
+	// RoomMessageType overrides them in turn.
+	FiringMessageType   string `json:"firing-message-type"`
+	ResolvedMessageType string `json:"resolved-message-type"`
+	// FiringText and ResolvedText render the plain-text body which is otherwise derived from the HTML.
+	FiringText   string `json:"firing-text-template"`
+	ResolvedText string `json:"resolved-text-template"`
+
+	// FiringFile, ResolvedFile, and GroupFile are read while parsing the configuration and replace the inline templates.
+	FiringFile       string `json:"firing-template-file"`
+	ResolvedFile     string `json:"resolved-template-file"`
+	GroupFile        string `json:"group-template-file"`
+	FiringTextFile   string `json:"firing-text-template-file"`
+	ResolvedTextFile string `json:"resolved-text-template-file"`
+	// Files is a glob of files containing shared templates which can be used in all other templates.
+	Files string `json:"template-files"`
+	// Templates are used instead of the firing and resolved templates above for alerts matching their matchers.
+	Templates []NamedTemplate `json:"templates"`
 }
 
 func (t *Templating) LogValue() slog.Value {
 	return slog.GroupValue(
 		slog.String("firing-template", t.Firing),
 		slog.String("resolved-template", t.Resolved),
+		slog.String("group-template", t.Group),
+		slog.String("firing-text-template", t.FiringText),
+		slog.String("resolved-text-template", t.ResolvedText),
+		slog.String("firing-template-file", t.FiringFile),
+		slog.String("resolved-template-file", t.ResolvedFile),
+		slog.String("group-template-file", t.GroupFile),
+		slog.String("firing-text-template-file", t.FiringTextFile),
+		slog.String("resolved-text-template-file", t.ResolvedTextFile),
+		slog.String("template-files", t.Files),
+		slog.Any("templates", t.Templates),
+		slog.String("notification-mode", t.NotificationMode),
+		slog.String("format", t.Format),
+		slog.String("message-type", t.MessageType),
+		slog.Any("room-message-type", t.RoomMessageType),
+		slog.Any("mentions", t.Mentions),
+		slog.String("firing-message-type", t.FiringMessageType),
+		slog.String("resolved-message-type", t.ResolvedMessageType),
+		slog.Any("room-notification-mode", t.RoomNotificationMode),
 		slog.Any("external-url-mapping", t.ExternalURLMapping),
 		slog.Any("computed-values", t.ComputedValues),
 	)
@@ -80,9 +381,92 @@ func (t *Templating) LogValue() slog.Value {
 
 type KeyValue map[string]string
 
+// NamedTemplate renders alerts which match all of its matchers. The first matching template is used.
+type NamedTemplate struct {
+	Name                string   `json:"name"`
+	LabelMatcher        KeyValue `json:"when-matching-labels"`
+	AnnotationMatcher   KeyValue `json:"when-matching-annotations"`
+	StatusMatcher       string   `json:"when-matching-status"`
+	Format              string   `json:"format"`
+	Firing              string   `json:"firing-template"`
+	Resolved            string   `json:"resolved-template"`
+	FiringText          string   `json:"firing-text-template"`
+	ResolvedText        string   `json:"resolved-text-template"`
+	FiringMessageType   string   `json:"firing-message-type"`
+	ResolvedMessageType string   `json:"resolved-message-type"`
+	FiringFile          string   `json:"firing-template-file"`
+	ResolvedFile        string   `json:"resolved-template-file"`
+	FiringTextFile      string   `json:"firing-text-template-file"`
+	ResolvedTextFile    string   `json:"resolved-text-template-file"`
+}
+
+const (
+	FormatHTML     = "html"
+	FormatMarkdown = "markdown"
+)
+
+const (
+	MessageTypeText   = "m.text"
+	MessageTypeNotice = "m.notice"
+)
+
+const (
+	NotificationModeAlert = "alert"
+	NotificationModeGroup = "group"
+)
+
 type ComputedValue struct {
 	Values            KeyValue `json:"values"`
 	LabelMatcher      KeyValue `json:"when-matching-labels"`
 	AnnotationMatcher KeyValue `json:"when-matching-annotations"`
 	StatusMatcher     string   `json:"when-matching-status"`
 }
+
+type Queue struct {
+	Directory      string         `json:"directory"`
+	Workers        int            `json:"workers"`
+	InitialBackoff model.Duration `json:"initial-backoff"`
+	MaxBackoff     model.Duration `json:"max-backoff"`
+	MaxAge         model.Duration `json:"max-age"`
+}
+
+func (q *Queue) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("directory", q.Directory),
+		slog.Int("workers", q.Workers),
+		slog.String("initial-backoff", q.InitialBackoff.String()),
+		slog.String("max-backoff", q.MaxBackoff.String()),
+		slog.String("max-age", q.MaxAge.String()),
+	)
+}
+
+func (q *Queue) Enabled() bool {
+	return q.Directory != ""
+}
+
+type State struct {
+	File string `json:"file"`
+}
+
+func (s *State) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("file", s.File),
+	)
+}
+
+// Alertmanager configures access to the v2 API of Alertmanager.
+type Alertmanager struct {
+	// URL of Alertmanager. Defaults to the external URL sent by Alertmanager along with the alerts. Credentials for
+	// basic authentication can be part of the URL.
+	URL string `json:"url"`
+}
+
+func (a *Alertmanager) LogValue() slog.Value {
+	redacted := a.URL
+	if parsed, err := url.Parse(a.URL); err == nil {
+		redacted = parsed.Redacted()
+	}
+	return slog.GroupValue(
+		slog.String("url", redacted),
+	)
+}
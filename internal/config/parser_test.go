@@ -34,10 +34,11 @@ templating:
 			},
 			expected: &Configuration{
 				HTTPServer: HTTPServer{
-					Port:             12345,
-					AlertsPathPrefix: "/alerts/",
-					MetricsPath:      "/metrics",
-					BasicUsername:    "alertmanager",
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
 				},
 				Matrix: Matrix{
 					HomeServerURL: "https://matrix.example.com",
@@ -49,6 +50,44 @@ templating:
 				},
 			},
 		},
+		"room-mapping": {
+			configuration: `
+http:
+  port: 12345
+matrix:
+  homeserver-url: https://matrix.example.com
+  user-id: "@user:matrix.example.com"
+  access-token: secret
+  room-mapping:
+    single: "!single:matrix.example.com"
+    multiple:
+      - "!first:matrix.example.com"
+      - "!second:matrix.example.com"
+templating:
+  firing-template: "something broke"
+`,
+			expected: &Configuration{
+				HTTPServer: HTTPServer{
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
+				},
+				Matrix: Matrix{
+					HomeServerURL: "https://matrix.example.com",
+					UserID:        "@user:matrix.example.com",
+					AccessToken:   "secret",
+					RoomMapping: map[string]RoomList{
+						"single":   {"!single:matrix.example.com"},
+						"multiple": {"!first:matrix.example.com", "!second:matrix.example.com"},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+		},
 	}
 	for name, testCase := range testCases {
 		t.Run(name, func(t *testing.T) {
@@ -7,17 +7,50 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
 )
 
+// Problem describes an invalid configuration value together with its YAML path.
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
 func validateConfiguration(ctx context.Context, configuration *Configuration) bool {
-	hasValidationErrors := false
+	problems := validate(configuration)
+	for _, problem := range problems {
+		slog.ErrorContext(ctx, "Invalid configuration", slog.String("path", problem.Path), slog.String("problem", problem.Message))
+	}
+	return len(problems) > 0
+}
+
+// validate checks the given configuration, sets default values and returns all problems found.
+func validate(configuration *Configuration) []Problem {
+	var problems []Problem
+	report := func(path string, format string, args ...any) {
+		problems = append(problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
 
 	http := &configuration.HTTPServer
 	if http.Port < 1 || 65535 < http.Port {
-		slog.ErrorContext(ctx, "Invalid HTTP port specified", slog.Int("port", http.Port))
-		hasValidationErrors = true
+		report("http.port", "invalid HTTP port %d specified", http.Port)
 	}
 	if strings.TrimSpace(http.AlertsPathPrefix) == "" {
 		http.AlertsPathPrefix = "/alerts"
@@ -34,35 +67,351 @@ func validateConfiguration(ctx context.Context, configuration *Configuration) bo
 	if !strings.HasPrefix(http.MetricsPath, "/") {
 		http.MetricsPath = "/" + http.MetricsPath
 	}
+	if strings.TrimSpace(http.PreviewPathPrefix) == "" {
+		http.PreviewPathPrefix = "/preview"
+	}
+	if !strings.HasPrefix(http.PreviewPathPrefix, "/") {
+		http.PreviewPathPrefix = "/" + http.PreviewPathPrefix
+	}
+	if !strings.HasSuffix(http.PreviewPathPrefix, "/") {
+		http.PreviewPathPrefix = http.PreviewPathPrefix + "/"
+	}
+	if http.PreviewEnabled && http.PreviewPathPrefix == http.AlertsPathPrefix {
+		report("http.preview-path-prefix", "preview path %s must differ from alerts path", http.PreviewPathPrefix)
+	}
 	if strings.TrimSpace(http.BasicUsername) == "" {
 		http.BasicUsername = "alertmanager"
 	}
+	if http.BasicPasswordFile != "" {
+		if err := readSecretFile(http.BasicPasswordFile, &http.BasicPassword); err != nil {
+			report("http.basic-password-file", "%v", err)
+		}
+	}
 
 	matrix := &configuration.Matrix
 	if strings.TrimSpace(matrix.HomeServerURL) == "" {
-		slog.ErrorContext(ctx, "No homeserver URL is set")
-		hasValidationErrors = true
+		report("matrix.homeserver-url", "no homeserver URL is set")
 	}
 	if strings.TrimSpace(matrix.UserID) == "" {
-		slog.ErrorContext(ctx, "No user ID is set")
-		hasValidationErrors = true
+		report("matrix.user-id", "no user ID is set")
 	}
-	if strings.TrimSpace(matrix.AccessToken) == "" {
-		slog.ErrorContext(ctx, "No access token is set")
-		hasValidationErrors = true
+	if matrix.AccessTokenFile != "" {
+		if err := readSecretFile(matrix.AccessTokenFile, &matrix.AccessToken); err != nil {
+			report("matrix.access-token-file", "%v", err)
+		}
+	} else if strings.TrimSpace(matrix.AccessToken) == "" {
+		report("matrix.access-token", "no access token is set")
 	}
-	for key, value := range matrix.RoomMapping {
-		if strings.TrimSpace(value) == "" {
-			slog.ErrorContext(ctx, "Empty room mapping value detected", slog.String("key", key))
-			hasValidationErrors = true
+	if matrix.Encryption.PickleKeyFile != "" {
+		if err := readSecretFile(matrix.Encryption.PickleKeyFile, &matrix.Encryption.PickleKey); err != nil {
+			report("matrix.encryption.pickle-key-file", "%v", err)
+		}
+	} else if matrix.Encryption.Enabled() && strings.TrimSpace(matrix.Encryption.PickleKey) == "" {
+		report("matrix.encryption.pickle-key", "no pickle key is set")
+	}
+	for _, key := range slices.Sorted(maps.Keys(matrix.RoomMapping)) {
+		rooms := matrix.RoomMapping[key]
+		path := "matrix.room-mapping." + key
+		if len(rooms) == 0 {
+			report(path, "empty room mapping value detected")
+		}
+		for _, room := range rooms {
+			if strings.TrimSpace(room) == "" {
+				report(path, "empty room mapping value detected")
+			} else if !isValidRoom(room) {
+				report(path, "room %q is neither a room ID nor a room alias", room)
+			}
 		}
 	}
 
-	templating := configuration.Templating
+	if !isValidUpdateMode(matrix.UpdateMode) {
+		report("matrix.update-mode", "invalid update mode %q specified", matrix.UpdateMode)
+	}
+	if matrix.ThreadKey != "" && matrix.ThreadKey != ThreadKeyFingerprint && matrix.ThreadKey != ThreadKeyGroupKey {
+		report("matrix.thread-key", "invalid thread key %q specified", matrix.ThreadKey)
+	}
+	if (matrix.UpdateMode == UpdateModeEdit || matrix.UpdateMode == UpdateModeThread) && matrix.UpdateRetention <= 0 {
+		matrix.UpdateRetention = model.Duration(7 * 24 * time.Hour)
+	}
+	if matrix.Silencing.Enabled() && matrix.Silencing.Duration <= 0 {
+		matrix.Silencing.Duration = model.Duration(2 * time.Hour)
+	}
+	if matrix.Acknowledgement.Enabled {
+		if matrix.Acknowledgement.Reaction == "" {
+			matrix.Acknowledgement.Reaction = "✅"
+		}
+		if matrix.Acknowledgement.Reply == "" {
+			matrix.Acknowledgement.Reply = "ack"
+		}
+		if matrix.Acknowledgement.Repeats == "" {
+			matrix.Acknowledgement.Repeats = RepeatsSuppress
+		}
+		if matrix.Acknowledgement.Repeats != RepeatsSuppress && matrix.Acknowledgement.Repeats != RepeatsShorten {
+			report("matrix.acknowledgement.repeats", "invalid repeat handling %q specified", matrix.Acknowledgement.Repeats)
+		}
+	}
+	if matrix.Escalation.Enabled() {
+		validateEscalation(&matrix.Escalation, matrix.Acknowledgement.Enabled, report)
+	}
+	if matrix.Interactive() && matrix.NotificationRetention <= 0 {
+		matrix.NotificationRetention = model.Duration(7 * 24 * time.Hour)
+	}
+
+	templating := &configuration.Templating
+	templateFiles := []templateFile{
+		{"templating.firing-template-file", templating.FiringFile, &templating.Firing},
+		{"templating.resolved-template-file", templating.ResolvedFile, &templating.Resolved},
+		{"templating.group-template-file", templating.GroupFile, &templating.Group},
+		{"templating.firing-text-template-file", templating.FiringTextFile, &templating.FiringText},
+		{"templating.resolved-text-template-file", templating.ResolvedTextFile, &templating.ResolvedText},
+	}
+	for index := range templating.Templates {
+		named := &templating.Templates[index]
+		path := fmt.Sprintf("templating.templates[%d]", index)
+		templateFiles = append(templateFiles,
+			templateFile{path + ".firing-template-file", named.FiringFile, &named.Firing},
+			templateFile{path + ".resolved-template-file", named.ResolvedFile, &named.Resolved},
+			templateFile{path + ".firing-text-template-file", named.FiringTextFile, &named.FiringText},
+			templateFile{path + ".resolved-text-template-file", named.ResolvedTextFile, &named.ResolvedText})
+	}
+	for _, templateFile := range templateFiles {
+		if templateFile.file == "" {
+			continue
+		}
+		if err := readTemplateFile(templateFile.file, templateFile.target); err != nil {
+			report(templateFile.path, "%v", err)
+		}
+	}
+	if templating.Files != "" {
+		if matches, err := filepath.Glob(templating.Files); err != nil {
+			report("templating.template-files", "invalid pattern: %v", err)
+		} else if len(matches) == 0 {
+			report("templating.template-files", "pattern matches no files")
+		}
+	}
 	if strings.TrimSpace(templating.Firing) == "" {
-		slog.ErrorContext(ctx, "No template for firing alerts defined")
-		hasValidationErrors = true
+		report("templating.firing-template", "no template for firing alerts defined")
+	}
+	var templateNames []string
+	for index, named := range templating.Templates {
+		path := fmt.Sprintf("templating.templates[%d]", index)
+		if strings.TrimSpace(named.Name) == "" {
+			report(path+".name", "no template name defined")
+		} else if slices.Contains(templateNames, named.Name) {
+			report(path+".name", "duplicate template name %q", named.Name)
+		}
+		templateNames = append(templateNames, named.Name)
+		if strings.TrimSpace(named.Firing) == "" {
+			report(path+".firing-template", "no template for firing alerts defined")
+		}
+		if !isValidFormat(named.Format) {
+			report(path+".format", "invalid format %q specified", named.Format)
+		}
+		if !isValidMessageType(named.FiringMessageType) {
+			report(path+".firing-message-type", "invalid message type %q specified", named.FiringMessageType)
+		}
+		if !isValidMessageType(named.ResolvedMessageType) {
+			report(path+".resolved-message-type", "invalid message type %q specified", named.ResolvedMessageType)
+		}
+	}
+	if !isValidFormat(templating.Format) {
+		report("templating.format", "invalid format %q specified", templating.Format)
+	}
+	for _, label := range slices.Sorted(maps.Keys(templating.Mentions)) {
+		for _, value := range slices.Sorted(maps.Keys(templating.Mentions[label])) {
+			for _, user := range templating.Mentions[label][value] {
+				if !isValidUser(user) {
+					report("templating.mentions."+label+"."+value, "%q is not a user ID", user)
+				}
+			}
+		}
+	}
+	if !isValidMessageType(templating.MessageType) {
+		report("templating.message-type", "invalid message type %q specified", templating.MessageType)
+	}
+	for _, room := range slices.Sorted(maps.Keys(templating.RoomMessageType)) {
+		if messageType := templating.RoomMessageType[room]; !isValidMessageType(messageType) {
+			report("templating.room-message-type."+room, "invalid room message type %q specified", messageType)
+		}
+	}
+	if !isValidMessageType(templating.FiringMessageType) {
+		report("templating.firing-message-type", "invalid message type %q specified", templating.FiringMessageType)
+	}
+	if !isValidMessageType(templating.ResolvedMessageType) {
+		report("templating.resolved-message-type", "invalid message type %q specified", templating.ResolvedMessageType)
 	}
+	if !isValidNotificationMode(templating.NotificationMode) {
+		report("templating.notification-mode", "invalid notification mode %q specified", templating.NotificationMode)
+	}
+	groupingUsed := templating.NotificationMode == NotificationModeGroup
+	for _, room := range slices.Sorted(maps.Keys(templating.RoomNotificationMode)) {
+		mode := templating.RoomNotificationMode[room]
+		if !isValidNotificationMode(mode) {
+			report("templating.room-notification-mode."+room, "invalid room notification mode %q specified", mode)
+		}
+		groupingUsed = groupingUsed || mode == NotificationModeGroup
+	}
+	if groupingUsed && strings.TrimSpace(templating.Group) == "" {
+		report("templating.group-template", "grouped notifications are enabled but no group template is defined")
+	}
+
+	for index, route := range configuration.Routes {
+		path := fmt.Sprintf("routes[%d]", index)
+		if _, err := ParseMatchers(route.Matchers); err != nil {
+			report(path+".matchers", "%v", err)
+		}
+		if len(route.Rooms) == 0 {
+			report(path+".rooms", "route without rooms detected")
+		}
+		for _, room := range route.Rooms {
+			if strings.TrimSpace(room) == "" {
+				report(path+".rooms", "empty route room detected")
+			}
+		}
+	}
+
+	queue := &configuration.Queue
+	if queue.Enabled() {
+		if queue.Workers < 0 {
+			report("queue.workers", "invalid number of queue workers %d specified", queue.Workers)
+		}
+		if queue.Workers == 0 {
+			queue.Workers = 1
+		}
+		if queue.InitialBackoff == 0 {
+			queue.InitialBackoff = model.Duration(5 * time.Second)
+		}
+		if queue.MaxBackoff == 0 {
+			queue.MaxBackoff = model.Duration(5 * time.Minute)
+		}
+		if queue.MaxAge == 0 {
+			queue.MaxAge = model.Duration(time.Hour)
+		}
+		if queue.MaxBackoff < queue.InitialBackoff {
+			report("queue.max-backoff", "maximum queue backoff %s must not be smaller than initial backoff %s",
+				queue.MaxBackoff, queue.InitialBackoff)
+		}
+	}
+
+	if alertmanagerURL := configuration.Alertmanager.URL; alertmanagerURL != "" {
+		if parsed, err := url.Parse(alertmanagerURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			report("alertmanager.url", "URL must use http or https and contain a host")
+		}
+	}
+
+	return problems
+}
+
+// readSecretFile reads the secret stored in the given file into target. Surrounding whitespace, e.g. a trailing
+// newline, is removed from the secret.
+func readSecretFile(path string, target *string) error {
+	if strings.TrimSpace(*target) != "" {
+		return fmt.Errorf("secret is set both inline and as file %s", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read secret file: %w", err)
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return fmt.Errorf("secret file %s is empty", path)
+	}
+	*target = secret
+	return nil
+}
 
-	return hasValidationErrors
+// templateFile is a file whose content is read into the template at target.
+type templateFile struct {
+	path   string
+	file   string
+	target *string
+}
+
+// readTemplateFile reads the template stored in the given file into target.
+func readTemplateFile(path string, target *string) error {
+	if strings.TrimSpace(*target) != "" {
+		return fmt.Errorf("template is set both inline and as file %s", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read template file: %w", err)
+	}
+	*target = string(content)
+	return nil
+}
+
+func isValidFormat(format string) bool {
+	return format == "" || format == FormatHTML || format == FormatMarkdown
+}
+
+func isValidMessageType(messageType string) bool {
+	return messageType == "" || messageType == MessageTypeText || messageType == MessageTypeNotice
+}
+
+func isValidNotificationMode(mode string) bool {
+	return mode == "" || mode == NotificationModeAlert || mode == NotificationModeGroup
+}
+
+func isValidUpdateMode(mode string) bool {
+	return mode == "" || mode == UpdateModeNew || mode == UpdateModeEdit || mode == UpdateModeThread
+}
+
+func validateEscalation(escalation *Escalation, acknowledgementEnabled bool, report func(path string, format string, args ...any)) {
+	if !acknowledgementEnabled {
+		report("matrix.escalation", "escalation requires matrix.acknowledgement to be enabled")
+	}
+	if escalation.Interval <= 0 {
+		escalation.Interval = model.Duration(time.Minute)
+	}
+	for index, policy := range escalation.Policies {
+		path := fmt.Sprintf("matrix.escalation.policies[%d]", index)
+		if _, err := ParseMatchers(policy.Matchers); err != nil {
+			report(path+".matchers", "%v", err)
+		}
+		for _, user := range policy.OnCall {
+			if !isValidUser(user) {
+				report(path+".on-call", "%q is not a user ID", user)
+			}
+		}
+		if len(policy.Steps) == 0 {
+			report(path+".steps", "policy without steps detected")
+		}
+		for stepIndex, step := range policy.Steps {
+			stepPath := fmt.Sprintf("%s.steps[%d]", path, stepIndex)
+			if step.After <= 0 {
+				report(stepPath+".after", "delay must be positive")
+			} else if stepIndex > 0 && step.After < policy.Steps[stepIndex-1].After {
+				report(stepPath+".after", "steps must be ordered by their delay")
+			}
+			switch step.Action {
+			case EscalationActionRoomMention:
+			case EscalationActionMention, EscalationActionDirectMessage:
+				if len(step.Users) == 0 && len(policy.OnCall) == 0 {
+					report(stepPath+".users", "action %q requires users or an on-call list", step.Action)
+				}
+			default:
+				report(stepPath+".action", "invalid escalation action %q specified", step.Action)
+			}
+			for _, user := range step.Users {
+				if !isValidUser(user) {
+					report(stepPath+".users", "%q is not a user ID", user)
+				}
+			}
+		}
+	}
+}
+
+// isValidUser checks whether the given user is a user ID (@localpart:server).
+func isValidUser(user string) bool {
+	localpart, server, found := strings.Cut(strings.TrimPrefix(user, "@"), ":")
+	return strings.HasPrefix(user, "@") && found && localpart != "" && server != ""
+}
+
+// isValidRoom checks whether the given room is a room ID (!opaque:server) or a room alias (#alias:server).
+func isValidRoom(room string) bool {
+	if !strings.HasPrefix(room, "!") && !strings.HasPrefix(room, "#") {
+		return false
+	}
+	localpart, server, found := strings.Cut(room[1:], ":")
+	return found && localpart != "" && server != ""
 }
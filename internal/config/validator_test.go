@@ -6,8 +6,12 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -63,6 +67,306 @@ func TestValidateConfiguration_Errors(t *testing.T) {
 			},
 			hasErrors: false,
 		},
+		"encryption-without-pickle-key": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Encryption: Encryption{
+						Database: "crypto.db",
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"encryption-with-pickle-key": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Encryption: Encryption{
+						Database:  "crypto.db",
+						PickleKey: "pickle",
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: false,
+		},
+		"invalid-alertmanager-url": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+				Alertmanager: Alertmanager{
+					URL: "alertmanager:9093",
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-acknowledgement-repeats": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+						Repeats: "ignore",
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"valid-escalation": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								Matchers: []string{`severity="critical"`},
+								OnCall:   UserList{"@alice:example.com", "@bob:example.com"},
+								Steps: []EscalationStep{
+									{After: model.Duration(15 * time.Minute), Action: EscalationActionRoomMention},
+									{After: model.Duration(30 * time.Minute), Action: EscalationActionMention},
+									{After: model.Duration(time.Hour), Action: EscalationActionDirectMessage, Users: UserList{"@carol:example.com"}},
+								},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: false,
+		},
+		"escalation-without-acknowledgement": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								Matchers: []string{`severity="critical"`},
+								OnCall:   UserList{"@alice:example.com", "@bob:example.com"},
+								Steps: []EscalationStep{
+									{After: model.Duration(15 * time.Minute), Action: EscalationActionRoomMention},
+									{After: model.Duration(30 * time.Minute), Action: EscalationActionMention},
+									{After: model.Duration(time.Hour), Action: EscalationActionDirectMessage, Users: UserList{"@carol:example.com"}},
+								},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-escalation-matchers": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								Matchers: []string{`severity=~"["`},
+								Steps:    []EscalationStep{{After: model.Duration(time.Minute), Action: EscalationActionRoomMention}},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"escalation-without-steps": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								Matchers: []string{`severity="critical"`},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-escalation-action": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								Steps: []EscalationStep{{After: model.Duration(time.Minute), Action: "page"}},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"escalation-mention-without-users": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								Steps: []EscalationStep{{After: model.Duration(time.Minute), Action: EscalationActionMention}},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"unordered-escalation-steps": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								Steps: []EscalationStep{
+									{After: model.Duration(time.Hour), Action: EscalationActionRoomMention},
+									{After: model.Duration(time.Minute), Action: EscalationActionRoomMention},
+								},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-escalation-user": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+					Escalation: Escalation{
+						Policies: []EscalationPolicy{
+							{
+								OnCall: UserList{"alice"},
+								Steps:  []EscalationStep{{After: model.Duration(time.Minute), Action: EscalationActionMention}},
+							},
+						},
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			hasErrors: true,
+		},
 		"detect-whitespace-only-template": {
 			configuration: &Configuration{
 				HTTPServer: HTTPServer{
@@ -74,18 +378,193 @@ func TestValidateConfiguration_Errors(t *testing.T) {
 					AccessToken:   "secret",
 				},
 				Templating: Templating{
-					Firing:   " ",
-					Resolved: " ",
+					Firing:   " ",
+					Resolved: " ",
+				},
+			},
+			hasErrors: true,
+		},
+		"detect-whitespace-only-homeserver": {
+			configuration: &Configuration{
+				Matrix: Matrix{
+					HomeServerURL: "",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+				},
+			},
+			hasErrors: true,
+		},
+		"detect-whitespace-only-user": {
+			configuration: &Configuration{
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+				},
+			},
+			hasErrors: true,
+		},
+		"detect-whitespace-only-token": {
+			configuration: &Configuration{
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "",
+				},
+				Templating: Templating{
+					Firing: "abc",
+				},
+			},
+			hasErrors: true,
+		},
+		"duplicate-template-name": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+					Templates: []NamedTemplate{
+						{Name: "critical", Firing: "abc"},
+						{Name: "critical", Firing: "def"},
+					},
+				},
+			},
+			hasErrors: true,
+		},
+		"named-template-without-firing-template": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+					Templates: []NamedTemplate{
+						{Name: "critical", Resolved: "abc"},
+					},
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-format": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+					Format: "asciidoc",
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-message-type": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+					RoomMessageType: KeyValue{
+						"warnings": "m.emote",
+					},
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-mention": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+					Mentions: map[string]map[string]UserList{
+						"owner": {"alice": {"alice"}},
+					},
+				},
+			},
+			hasErrors: true,
+		},
+		"invalid-update-mode": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					UpdateMode:    "something",
+				},
+				Templating: Templating{
+					Firing: "abc",
 				},
 			},
 			hasErrors: true,
 		},
-		"detect-whitespace-only-homeserver": {
+		"room-mapping-with-ids-and-aliases": {
 			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
 				Matrix: Matrix{
-					HomeServerURL: "",
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					RoomMapping: map[string]RoomList{
+						"warnings": {"!opaque:example.com", "#alerts:example.com"},
+					},
+				},
+				Templating: Templating{
+					Firing: "abc",
+				},
+			},
+			hasErrors: false,
+		},
+		"invalid-room-mapping": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
 					UserID:        "12345",
 					AccessToken:   "secret",
+					RoomMapping: map[string]RoomList{
+						"warnings": {"alerts"},
+					},
 				},
 				Templating: Templating{
 					Firing: "abc",
@@ -93,32 +572,103 @@ func TestValidateConfiguration_Errors(t *testing.T) {
 			},
 			hasErrors: true,
 		},
-		"detect-whitespace-only-user": {
+		"invalid-route-matcher": {
 			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
 				Matrix: Matrix{
 					HomeServerURL: "example.com",
-					UserID:        "",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "abc",
+				},
+				Routes: []Route{
+					{
+						Matchers: []string{`severity=~"(`},
+						Rooms:    []string{"warnings"},
+					},
+				},
+			},
+			hasErrors: true,
+		},
+		"route-without-rooms": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
 					AccessToken:   "secret",
 				},
 				Templating: Templating{
 					Firing: "abc",
 				},
+				Routes: []Route{
+					{
+						Matchers: []string{`severity="critical"`},
+					},
+				},
 			},
 			hasErrors: true,
 		},
-		"detect-whitespace-only-token": {
+		"invalid-notification-mode": {
 			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
 				Matrix: Matrix{
 					HomeServerURL: "example.com",
 					UserID:        "12345",
-					AccessToken:   "",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing:           "abc",
+					NotificationMode: "something",
+				},
+			},
+			hasErrors: true,
+		},
+		"group-mode-without-template": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
 				},
 				Templating: Templating{
 					Firing: "abc",
+					RoomNotificationMode: KeyValue{
+						"warnings": NotificationModeGroup,
+					},
 				},
 			},
 			hasErrors: true,
 		},
+		"group-mode-with-template": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing:           "abc",
+					Group:            "{{ .FiringCount }} alerts firing",
+					NotificationMode: NotificationModeGroup,
+				},
+			},
+			hasErrors: false,
+		},
 	}
 	for name, testCase := range testCases {
 		t.Run(name, func(t *testing.T) {
@@ -149,10 +699,11 @@ func TestValidateConfiguration_AdjustedValues(t *testing.T) {
 			},
 			expected: &Configuration{
 				HTTPServer: HTTPServer{
-					Port:             12345,
-					AlertsPathPrefix: "/alerts/",
-					MetricsPath:      "/metrics",
-					BasicUsername:    "alertmanager",
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
 				},
 				Matrix: Matrix{
 					HomeServerURL: "example.com",
@@ -181,15 +732,214 @@ func TestValidateConfiguration_AdjustedValues(t *testing.T) {
 			},
 			expected: &Configuration{
 				HTTPServer: HTTPServer{
-					Port:             12345,
-					AlertsPathPrefix: "/alerts/",
-					MetricsPath:      "/somewhere-metrics",
-					BasicUsername:    "alertmanager",
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/somewhere-metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+		},
+		"with-queue-defaults": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+				Queue: Queue{
+					Directory: "/var/lib/matrix-alertmanager-receiver",
+				},
+			},
+			expected: &Configuration{
+				HTTPServer: HTTPServer{
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+				Queue: Queue{
+					Directory:      "/var/lib/matrix-alertmanager-receiver",
+					Workers:        1,
+					InitialBackoff: model.Duration(5 * time.Second),
+					MaxBackoff:     model.Duration(5 * time.Minute),
+					MaxAge:         model.Duration(time.Hour),
+				},
+			},
+		},
+		"with-update-retention-defaults": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					UpdateMode:    UpdateModeThread,
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			expected: &Configuration{
+				HTTPServer: HTTPServer{
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
+				},
+				Matrix: Matrix{
+					HomeServerURL:   "example.com",
+					UserID:          "12345",
+					AccessToken:     "secret",
+					UpdateMode:      UpdateModeThread,
+					UpdateRetention: model.Duration(7 * 24 * time.Hour),
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+		},
+		"with-command-defaults": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Commands: Commands{
+						Enabled: true,
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			expected: &Configuration{
+				HTTPServer: HTTPServer{
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Commands: Commands{
+						Enabled: true,
+					},
+					NotificationRetention: model.Duration(7 * 24 * time.Hour),
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+		},
+		"with-silencing-defaults": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Silencing: Silencing{
+						Reaction: "🔕",
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			expected: &Configuration{
+				HTTPServer: HTTPServer{
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Silencing: Silencing{
+						Reaction: "🔕",
+						Duration: model.Duration(2 * time.Hour),
+					},
+					NotificationRetention: model.Duration(7 * 24 * time.Hour),
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+		},
+		"with-acknowledgement-defaults": {
+			configuration: &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled: true,
+					},
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			},
+			expected: &Configuration{
+				HTTPServer: HTTPServer{
+					Port:              12345,
+					AlertsPathPrefix:  "/alerts/",
+					MetricsPath:       "/metrics",
+					PreviewPathPrefix: "/preview/",
+					BasicUsername:     "alertmanager",
 				},
 				Matrix: Matrix{
 					HomeServerURL: "example.com",
 					UserID:        "12345",
 					AccessToken:   "secret",
+					Acknowledgement: Acknowledgement{
+						Enabled:  true,
+						Reaction: "✅",
+						Reply:    "ack",
+						Repeats:  RepeatsSuppress,
+					},
+					NotificationRetention: model.Duration(7 * 24 * time.Hour),
 				},
 				Templating: Templating{
 					Firing: "something broke",
@@ -205,3 +955,117 @@ func TestValidateConfiguration_AdjustedValues(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateConfiguration_SecretFiles(t *testing.T) {
+	ctx := t.Context()
+	directory := t.TempDir()
+	tokenFile := filepath.Join(directory, "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("file-secret\n"), 0o600))
+	passwordFile := filepath.Join(directory, "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("password"), 0o600))
+	emptyFile := filepath.Join(directory, "empty")
+	assert.NoError(t, os.WriteFile(emptyFile, []byte(" \n"), 0o600))
+
+	testCases := map[string]struct {
+		accessToken       string
+		accessTokenFile   string
+		basicPasswordFile string
+		hasErrors         bool
+		expectedToken     string
+		expectedPassword  string
+	}{
+		"read-files": {
+			accessTokenFile:   tokenFile,
+			basicPasswordFile: passwordFile,
+			expectedToken:     "file-secret",
+			expectedPassword:  "password",
+		},
+		"missing-file": {
+			accessTokenFile: filepath.Join(directory, "missing"),
+			hasErrors:       true,
+		},
+		"empty-file": {
+			accessTokenFile: emptyFile,
+			hasErrors:       true,
+		},
+		"inline-and-file": {
+			accessToken:     "inline-secret",
+			accessTokenFile: tokenFile,
+			hasErrors:       true,
+			expectedToken:   "inline-secret",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			configuration := &Configuration{
+				HTTPServer: HTTPServer{
+					Port:              12345,
+					BasicPasswordFile: testCase.basicPasswordFile,
+				},
+				Matrix: Matrix{
+					HomeServerURL:   "example.com",
+					UserID:          "12345",
+					AccessToken:     testCase.accessToken,
+					AccessTokenFile: testCase.accessTokenFile,
+				},
+				Templating: Templating{
+					Firing: "something broke",
+				},
+			}
+			hasErrors := validateConfiguration(ctx, configuration)
+			assert.Equal(t, testCase.hasErrors, hasErrors)
+			assert.Equal(t, testCase.expectedToken, configuration.Matrix.AccessToken)
+			assert.Equal(t, testCase.expectedPassword, configuration.HTTPServer.BasicPassword)
+		})
+	}
+}
+
+func TestValidateConfiguration_TemplateFiles(t *testing.T) {
+	ctx := t.Context()
+	directory := t.TempDir()
+	firingFile := filepath.Join(directory, "firing.html")
+	assert.NoError(t, os.WriteFile(firingFile, []byte("<b>firing</b>\n"), 0o600))
+
+	testCases := map[string]struct {
+		templating     Templating
+		hasErrors      bool
+		expectedFiring string
+	}{
+		"read-file": {
+			templating:     Templating{FiringFile: firingFile, Files: filepath.Join(directory, "*.html")},
+			expectedFiring: "<b>firing</b>\n",
+		},
+		"missing-file": {
+			templating: Templating{FiringFile: filepath.Join(directory, "missing.html")},
+			hasErrors:  true,
+		},
+		"inline-and-file": {
+			templating:     Templating{Firing: "inline", FiringFile: firingFile},
+			hasErrors:      true,
+			expectedFiring: "inline",
+		},
+		"glob-without-matches": {
+			templating:     Templating{Firing: "inline", Files: filepath.Join(directory, "*.tmpl")},
+			hasErrors:      true,
+			expectedFiring: "inline",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			configuration := &Configuration{
+				HTTPServer: HTTPServer{
+					Port: 12345,
+				},
+				Matrix: Matrix{
+					HomeServerURL: "example.com",
+					UserID:        "12345",
+					AccessToken:   "secret",
+				},
+				Templating: testCase.templating,
+			}
+			hasErrors := validateConfiguration(ctx, configuration)
+			assert.Equal(t, testCase.hasErrors, hasErrors)
+			assert.Equal(t, testCase.expectedFiring, configuration.Templating.Firing)
+		})
+	}
+}
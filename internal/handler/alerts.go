@@ -7,11 +7,15 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 
 	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
 	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	amtemplate "github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -37,9 +41,13 @@ var (
 		Name: "matrix_alertmanager_receiver_alerts_total",
 		Help: "The total number of alerts processed",
 	}, []string{"room"})
+	failedAlertsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_failed_alerts_total",
+		Help: "The total number of alerts that could not be delivered",
+	})
 )
 
-func AlertsHandler(ctx context.Context, sendingFunc matrix.SendingFunc, templatingFunc alertmanager.TemplatingFunc, roomExtractorFunc RoomExtractorFunc, authorizerFunc AuthorizerFunc) http.HandlerFunc {
+func AlertsHandler(ctx context.Context, sendingFunc matrix.SendingFunc, renderingFunc RenderingFunc, roomExtractorFunc RoomExtractorFunc, authorizerFunc AuthorizerFunc) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		httpRequestsTotal.Inc()
 
@@ -57,7 +65,7 @@ func AlertsHandler(ctx context.Context, sendingFunc matrix.SendingFunc, templati
 			return
 		}
 
-		data, err := alertmanager.DecodePayload(request.Body)
+		payload, err := alertmanager.DecodePayload(request.Body)
 		if err != nil {
 			invalidPayloadTotal.Inc()
 			slog.ErrorContext(ctx, "Received invalid data", slog.Any("error", err))
@@ -69,13 +77,78 @@ func AlertsHandler(ctx context.Context, sendingFunc matrix.SendingFunc, templati
 		room := roomExtractorFunc(request)
 		slog.DebugContext(ctx, "Extracted roomID", slog.String("room", room))
 
-		for _, alert := range data.Alerts {
-			alertsTotal.WithLabelValues(room).Inc()
-			if message, templateError := templatingFunc(alert, data); templateError == nil {
-				slog.DebugContext(ctx, "Created message", slog.String("html", message))
-				sendingFunc(message, room)
+		var failures []deliveryFailure
+		retry := false
+		for _, notification := range renderingFunc(payload, room) {
+			alertsTotal.WithLabelValues(notification.Message.Room).Add(float64(len(notification.Alerts)))
+			if retryable, err := deliver(notification, sendingFunc); err != nil {
+				retry = retry || retryable
+				for _, alert := range notification.Alerts {
+					failures = append(failures, newDeliveryFailure(alert, notification.Message.Room, err, retryable))
+				}
 			}
 		}
+
+		if len(failures) > 0 {
+			failedAlertsTotal.Add(float64(len(failures)))
+			slog.ErrorContext(ctx, "Could not deliver all alerts", slog.Int("failed", len(failures)), slog.Int("total", len(payload.Alerts)), slog.Bool("retry", retry))
+			writeFailures(ctx, writer, failures, retry)
+			return
+		}
 		writer.WriteHeader(http.StatusOK)
 	}
 }
+
+type deliveryFailure struct {
+	Fingerprint string        `json:"fingerprint"`
+	Status      string        `json:"status"`
+	Labels      amtemplate.KV `json:"labels"`
+	Room        string        `json:"room"`
+	Error       string        `json:"error"`
+	Retryable   bool          `json:"retryable"`
+}
+
+type deliveryFailures struct {
+	Failed []deliveryFailure `json:"failed"`
+}
+
+func newDeliveryFailure(alert amtemplate.Alert, room string, err error, retryable bool) deliveryFailure {
+	return deliveryFailure{
+		Fingerprint: alert.Fingerprint,
+		Status:      alert.Status,
+		Labels:      alert.Labels,
+		Room:        room,
+		Error:       err.Error(),
+		Retryable:   retryable,
+	}
+}
+
+// deliver sends the message of a notification and returns whether a failed delivery is worth retrying. Only failures
+// of the sending function are, since missing rooms and template errors would fail the same way on every retry.
+func deliver(notification Notification, sendingFunc matrix.SendingFunc) (bool, error) {
+	if errors.Is(notification.Error, errNoRoom) {
+		return false, notification.Error
+	}
+	if notification.Error != nil {
+		return false, fmt.Errorf("could not template message: %w", notification.Error)
+	}
+	if err := sendingFunc(notification.Message); err != nil {
+		return true, err
+	}
+	return false, nil
+}
+
+// writeFailures lists the alerts that could not be delivered. It answers with a 5xx status code in case a failure is
+// retryable so that Alertmanager retries the notification. Permanent failures alone are answered with 200, since
+// retries would re-send the alerts delivered into other rooms without ever delivering the failed ones.
+func writeFailures(ctx context.Context, writer http.ResponseWriter, failures []deliveryFailure, retry bool) {
+	writer.Header().Set("Content-Type", "application/json")
+	if retry {
+		writer.WriteHeader(http.StatusBadGateway)
+	} else {
+		writer.WriteHeader(http.StatusOK)
+	}
+	if err := json.NewEncoder(writer).Encode(deliveryFailures{Failed: failures}); err != nil {
+		slog.ErrorContext(ctx, "Could not write failure response", slog.Any("error", err))
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPayload = `{
+  "status": "firing",
+  "alerts": [
+    {"status": "firing", "fingerprint": "first", "labels": {"alertname": "first"}},
+    {"status": "firing", "fingerprint": "second", "labels": {"alertname": "second"}}
+  ]
+}`
+
+func TestAlertsHandler_Delivery(t *testing.T) {
+	testCases := map[string]struct {
+		sendingFunc       matrix.SendingFunc
+		grouped           bool
+		brokenTemplate    bool
+		expectedStatus    int
+		expectedFailed    []string
+		expectedRetryable bool
+	}{
+		"all-delivered": {
+			sendingFunc:    func(message matrix.Message) error { return nil },
+			expectedStatus: http.StatusOK,
+		},
+		"one-failed": {
+			sendingFunc: func(message matrix.Message) error {
+				if message.HTML == "second" {
+					return errors.New("homeserver unavailable")
+				}
+				return nil
+			},
+			expectedStatus:    http.StatusBadGateway,
+			expectedFailed:    []string{"second"},
+			expectedRetryable: true,
+		},
+		"group-failed": {
+			sendingFunc:       func(message matrix.Message) error { return errors.New("homeserver unavailable") },
+			grouped:           true,
+			expectedStatus:    http.StatusBadGateway,
+			expectedFailed:    []string{"first", "second"},
+			expectedRetryable: true,
+		},
+		"template-failed": {
+			sendingFunc:    func(message matrix.Message) error { return nil },
+			brokenTemplate: true,
+			expectedStatus: http.StatusOK,
+			expectedFailed: []string{"second"},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			renderingFunc := CreateRenderingFunc(t.Context(),
+				func(alert amtemplate.Alert, data *amtemplate.Data) (alertmanager.Rendered, error) {
+					if testCase.brokenTemplate && alert.Fingerprint == "second" {
+						return alertmanager.Rendered{}, errors.New("broken template")
+					}
+					return alertmanager.Rendered{HTML: alert.Fingerprint}, nil
+				},
+				func(data *amtemplate.Data) (alertmanager.Rendered, error) {
+					return alertmanager.Rendered{HTML: "group"}, nil
+				},
+				func(room string) bool { return testCase.grouped },
+				func(room string, status string) string { return "" },
+				func(alert amtemplate.Alert, room string) []string { return []string{room} },
+				matrix.CreateRoomResolver(config.Matrix{}))
+			alertsHandler := AlertsHandler(t.Context(),
+				testCase.sendingFunc,
+				renderingFunc,
+				CreateRoomExtractor("/alerts/"),
+				CreateAlwaysAllowedAuthorizer())
+			request := httptest.NewRequest(http.MethodPost, "/alerts/room", strings.NewReader(testPayload))
+			recorder := httptest.NewRecorder()
+
+			alertsHandler(recorder, request)
+
+			assert.Equal(t, testCase.expectedStatus, recorder.Code)
+			if len(testCase.expectedFailed) > 0 {
+				var response deliveryFailures
+				assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+				var failed []string
+				for _, failure := range response.Failed {
+					assert.Equal(t, "room", failure.Room)
+					assert.Equal(t, testCase.expectedRetryable, failure.Retryable)
+					failed = append(failed, failure.Fingerprint)
+				}
+				assert.Equal(t, testCase.expectedFailed, failed)
+			}
+		})
+	}
+}
+
+func TestAlertsHandler_NoRoom(t *testing.T) {
+	renderingFunc := CreateRenderingFunc(t.Context(),
+		func(alert amtemplate.Alert, data *amtemplate.Data) (alertmanager.Rendered, error) {
+			return alertmanager.Rendered{HTML: alert.Fingerprint}, nil
+		},
+		func(data *amtemplate.Data) (alertmanager.Rendered, error) {
+			return alertmanager.Rendered{HTML: "group"}, nil
+		},
+		func(room string) bool { return false },
+		func(room string, status string) string { return "" },
+		func(alert amtemplate.Alert, room string) []string { return []string{room} },
+		matrix.CreateRoomResolver(config.Matrix{}))
+	alertsHandler := AlertsHandler(t.Context(),
+		func(message matrix.Message) error {
+			t.Errorf("unexpected message for room %q", message.Room)
+			return nil
+		},
+		renderingFunc,
+		CreateRoomExtractor("/alerts/"),
+		CreateAlwaysAllowedAuthorizer())
+	request := httptest.NewRequest(http.MethodPost, "/alerts/", strings.NewReader(testPayload))
+	recorder := httptest.NewRecorder()
+
+	alertsHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response deliveryFailures
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Len(t, response.Failed, 2)
+	for _, failure := range response.Failed {
+		assert.Equal(t, errNoRoom.Error(), failure.Error)
+		assert.False(t, failure.Retryable)
+	}
+}
@@ -0,0 +1,21 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+)
+
+type GroupingFunc func(room string) bool
+
+func CreateGroupingFunc(configuration config.Templating) GroupingFunc {
+	return func(room string) bool {
+		if mode, ok := configuration.RoomNotificationMode[room]; ok && mode != "" {
+			return mode == config.NotificationModeGroup
+		}
+		return configuration.NotificationMode == config.NotificationModeGroup
+	}
+}
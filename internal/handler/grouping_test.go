@@ -0,0 +1,67 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupingFunc(t *testing.T) {
+	testCases := map[string]struct {
+		configuration config.Templating
+		room          string
+		expected      bool
+	}{
+		"default": {
+			configuration: config.Templating{},
+			room:          "warnings",
+			expected:      false,
+		},
+		"global-group": {
+			configuration: config.Templating{
+				NotificationMode: config.NotificationModeGroup,
+			},
+			room:     "warnings",
+			expected: true,
+		},
+		"room-group": {
+			configuration: config.Templating{
+				RoomNotificationMode: config.KeyValue{
+					"warnings": config.NotificationModeGroup,
+				},
+			},
+			room:     "warnings",
+			expected: true,
+		},
+		"room-alert-overrides-global": {
+			configuration: config.Templating{
+				NotificationMode: config.NotificationModeGroup,
+				RoomNotificationMode: config.KeyValue{
+					"warnings": config.NotificationModeAlert,
+				},
+			},
+			room:     "warnings",
+			expected: false,
+		},
+		"other-room": {
+			configuration: config.Templating{
+				RoomNotificationMode: config.KeyValue{
+					"warnings": config.NotificationModeGroup,
+				},
+			},
+			room:     "critical",
+			expected: false,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, CreateGroupingFunc(testCase.configuration)(testCase.room))
+		})
+	}
+}
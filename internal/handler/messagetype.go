@@ -0,0 +1,45 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/prometheus/common/model"
+)
+
+// MessageTypeFunc returns the Matrix message type of messages about alerts with the given status sent into the given
+// room, unless a named template overrides it. Grouped notifications pass an empty status, since they are not rendered
+// with the firing and resolved templates. An empty result uses the default message type of the sending function.
+type MessageTypeFunc func(room string, status string) string
+
+// CreateMessageTypeFunc ranks the message type of a room above the message types of the firing and resolved templates,
+// which rank above the global message type.
+func CreateMessageTypeFunc(configuration config.Templating) MessageTypeFunc {
+	return func(room string, status string) string {
+		if messageType, ok := configuration.RoomMessageType[room]; ok && messageType != "" {
+			return messageType
+		}
+		if messageType := templateMessageType(configuration, status); messageType != "" {
+			return messageType
+		}
+		return configuration.MessageType
+	}
+}
+
+// templateMessageType returns the message type of the firing or resolved template. The firing message type is used for
+// resolved alerts as well in case no resolved template is given.
+func templateMessageType(configuration config.Templating, status string) string {
+	switch status {
+	case string(model.AlertFiring):
+		return configuration.FiringMessageType
+	case string(model.AlertResolved):
+		if configuration.ResolvedMessageType == "" && configuration.Resolved == "" {
+			return configuration.FiringMessageType
+		}
+		return configuration.ResolvedMessageType
+	}
+	return ""
+}
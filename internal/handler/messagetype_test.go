@@ -0,0 +1,103 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageTypeFunc(t *testing.T) {
+	testCases := map[string]struct {
+		configuration config.Templating
+		room          string
+		status        string
+		expected      string
+	}{
+		"default": {
+			configuration: config.Templating{},
+			room:          "warnings",
+			expected:      "",
+		},
+		"global-notice": {
+			configuration: config.Templating{
+				MessageType: config.MessageTypeNotice,
+			},
+			room:     "warnings",
+			expected: config.MessageTypeNotice,
+		},
+		"room-text-overrides-global": {
+			configuration: config.Templating{
+				MessageType: config.MessageTypeNotice,
+				RoomMessageType: config.KeyValue{
+					"warnings": config.MessageTypeText,
+				},
+			},
+			room:     "warnings",
+			expected: config.MessageTypeText,
+		},
+		"other-room": {
+			configuration: config.Templating{
+				RoomMessageType: config.KeyValue{
+					"warnings": config.MessageTypeNotice,
+				},
+			},
+			room:     "critical",
+			expected: "",
+		},
+		"room-overrides-template": {
+			configuration: config.Templating{
+				RoomMessageType: config.KeyValue{
+					"simple-name": config.MessageTypeNotice,
+				},
+				FiringMessageType: config.MessageTypeText,
+			},
+			room:     "simple-name",
+			status:   "firing",
+			expected: config.MessageTypeNotice,
+		},
+		"template-overrides-global": {
+			configuration: config.Templating{
+				MessageType:         config.MessageTypeText,
+				ResolvedMessageType: config.MessageTypeNotice,
+			},
+			room:     "warnings",
+			status:   "resolved",
+			expected: config.MessageTypeNotice,
+		},
+		"resolved-uses-firing": {
+			configuration: config.Templating{
+				FiringMessageType: config.MessageTypeNotice,
+			},
+			room:     "warnings",
+			status:   "resolved",
+			expected: config.MessageTypeNotice,
+		},
+		"resolved-template-without-message-type": {
+			configuration: config.Templating{
+				Resolved:          "resolved",
+				FiringMessageType: config.MessageTypeNotice,
+			},
+			room:     "warnings",
+			status:   "resolved",
+			expected: "",
+		},
+		"group-ignores-template": {
+			configuration: config.Templating{
+				FiringMessageType: config.MessageTypeNotice,
+			},
+			room:     "warnings",
+			expected: "",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, CreateMessageTypeFunc(testCase.configuration)(testCase.room, testCase.status))
+		})
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"maunium.net/go/mautrix/event"
+)
+
+type alertPreview struct {
+	Fingerprint string        `json:"fingerprint"`
+	Status      string        `json:"status"`
+	Labels      amtemplate.KV `json:"labels"`
+	alertmanager.Explanation
+	Rooms []roomPreview `json:"rooms"`
+}
+
+type roomPreview struct {
+	Room        string          `json:"room"`
+	Template    string          `json:"template"`
+	MessageType string          `json:"message-type,omitempty"`
+	HTML        string          `json:"html,omitempty"`
+	Text        string          `json:"text,omitempty"`
+	Mentions    *event.Mentions `json:"mentions,omitempty"`
+	Error       string          `json:"error,omitempty"`
+}
+
+type previews struct {
+	Alerts []*alertPreview `json:"alerts"`
+}
+
+// PreviewHandler answers with the messages that would be sent for the received payload without sending them.
+func PreviewHandler(ctx context.Context, renderingFunc RenderingFunc, explainingFunc alertmanager.ExplainingFunc, roomExtractorFunc RoomExtractorFunc, authorizerFunc AuthorizerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !authorizerFunc(request) {
+			unauthorizedRequestsTotal.Inc()
+			slog.ErrorContext(ctx, "Not authorized to perform request")
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if request.Method != http.MethodPost {
+			slog.ErrorContext(ctx, "Unsupported HTTP method used", slog.String("method", request.Method))
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		payload, err := alertmanager.DecodePayload(request.Body)
+		if err != nil {
+			slog.ErrorContext(ctx, "Received invalid data", slog.Any("error", err))
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		room := roomExtractorFunc(request)
+		slog.DebugContext(ctx, "Extracted roomID", slog.String("room", room))
+
+		writer.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(writer).Encode(preview(payload, room, renderingFunc, explainingFunc)); err != nil {
+			slog.ErrorContext(ctx, "Could not write preview response", slog.Any("error", err))
+		}
+	}
+}
+
+// preview describes every alert of the payload in the order they were received together with all rooms it is
+// delivered into.
+func preview(payload *alertmanager.Payload, room string, renderingFunc RenderingFunc, explainingFunc alertmanager.ExplainingFunc) previews {
+	var result previews
+	byKey := make(map[string]*alertPreview)
+	for _, alert := range payload.Alerts {
+		alertPreview := &alertPreview{
+			Fingerprint: alert.Fingerprint,
+			Status:      alert.Status,
+			Labels:      alert.Labels,
+			Explanation: explainingFunc(alert, &payload.Data),
+			Rooms:       []roomPreview{},
+		}
+		byKey[alertKey(alert)] = alertPreview
+		result.Alerts = append(result.Alerts, alertPreview)
+	}
+	for _, notification := range renderingFunc(payload, room) {
+		for _, alert := range notification.Alerts {
+			alertPreview := byKey[alertKey(alert)]
+			roomPreview := roomPreview{
+				Room:     notification.Message.Room,
+				Template: alertPreview.Template,
+			}
+			if notification.Grouped {
+				roomPreview.Template = "group"
+			}
+			if notification.Error != nil {
+				roomPreview.Error = notification.Error.Error()
+			} else {
+				content := notification.Message.Content()
+				roomPreview.MessageType = string(content.MsgType)
+				roomPreview.HTML = notification.Message.HTML
+				roomPreview.Text = content.Body
+				if content.Mentions != nil && (len(content.Mentions.UserIDs) > 0 || content.Mentions.Room) {
+					roomPreview.Mentions = content.Mentions
+				}
+			}
+			alertPreview.Rooms = append(alertPreview.Rooms, roomPreview)
+		}
+	}
+	return result
+}
+
+// alertKey identifies an alert within a payload. Payloads which were not sent by an Alertmanager might lack
+// fingerprints.
+func alertKey(alert amtemplate.Alert) string {
+	if alert.Fingerprint != "" {
+		return alert.Fingerprint
+	}
+	return fmt.Sprint(alert.Labels.SortedPairs())
+}
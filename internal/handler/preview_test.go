@@ -0,0 +1,98 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	ctx := t.Context()
+	templating := config.Templating{
+		Firing: `<b>{{ .Alert.Labels.alertname }}</b> {{ .ComputedValues.color }}`,
+		Group:  `{{ .FiringCount }} firing`,
+		ComputedValues: []config.ComputedValue{
+			{Values: config.KeyValue{"color": "red"}, LabelMatcher: config.KeyValue{"alertname": "first"}},
+		},
+	}
+	templatingFunc, err := alertmanager.CreateTemplatingFunc(ctx, templating)
+	assert.NoError(t, err)
+	groupTemplatingFunc, err := alertmanager.CreateGroupTemplatingFunc(ctx, templating)
+	assert.NoError(t, err)
+	renderingFunc := CreateRenderingFunc(ctx, templatingFunc, groupTemplatingFunc,
+		func(room string) bool { return room == "grouped" },
+		func(room string, status string) string {
+			if room == "grouped" {
+				return config.MessageTypeNotice
+			}
+			return ""
+		},
+		func(alert amtemplate.Alert, room string) []string {
+			if alert.Labels["alertname"] == "first" {
+				return []string{room, "grouped"}
+			}
+			return []string{room}
+		},
+		matrix.CreateRoomResolver(config.Matrix{}))
+	previewHandler := PreviewHandler(ctx, renderingFunc, alertmanager.CreateExplainingFunc(ctx, templating),
+		CreateRoomExtractor("/preview/"), CreateAlwaysAllowedAuthorizer())
+
+	request := httptest.NewRequest(http.MethodPost, "/preview/room", strings.NewReader(testPayload))
+	recorder := httptest.NewRecorder()
+	previewHandler(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response previews
+	assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, previews{
+		Alerts: []*alertPreview{
+			{
+				Fingerprint: "first",
+				Status:      "firing",
+				Labels:      amtemplate.KV{"alertname": "first"},
+				Explanation: alertmanager.Explanation{
+					Template:       "firing",
+					ComputedValues: map[string]string{"color": "red"},
+				},
+				Rooms: []roomPreview{
+					{Room: "room", Template: "firing", MessageType: "m.text", HTML: "<b>first</b> red", Text: "**first** red"},
+					{Room: "grouped", Template: "group", MessageType: "m.notice", HTML: "1 firing", Text: "1 firing"},
+				},
+			},
+			{
+				Fingerprint: "second",
+				Status:      "firing",
+				Labels:      amtemplate.KV{"alertname": "second"},
+				Explanation: alertmanager.Explanation{
+					Template:       "firing",
+					ComputedValues: map[string]string{},
+				},
+				Rooms: []roomPreview{
+					{Room: "room", Template: "firing", MessageType: "m.text", HTML: "<b>second</b> ", Text: "**second**"},
+				},
+			},
+		},
+	}, response)
+}
+
+func TestPreviewHandler_Unauthorized(t *testing.T) {
+	previewHandler := PreviewHandler(t.Context(), nil, nil, CreateRoomExtractor("/preview/"),
+		CreateBasicAuthAuthorizer("alertmanager", "secret"))
+	request := httptest.NewRequest(http.MethodPost, "/preview/room", strings.NewReader(testPayload))
+	recorder := httptest.NewRecorder()
+	previewHandler(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
@@ -0,0 +1,166 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+)
+
+// Notification is a rendered message together with the alerts it was rendered for. Error is set in case the message
+// could not be rendered.
+type Notification struct {
+	Message matrix.Message
+	Alerts  amtemplate.Alerts
+	Grouped bool
+	Error   error
+}
+
+// errNoRoom is the error of notifications for alerts which were not routed into any room.
+var errNoRoom = errors.New("no room selected for alert")
+
+// RenderingFunc routes the alerts of a payload received for the given room and renders all resulting messages
+// without sending them.
+type RenderingFunc func(payload *alertmanager.Payload, room string) []Notification
+
+func CreateRenderingFunc(ctx context.Context, templatingFunc alertmanager.TemplatingFunc, groupTemplatingFunc alertmanager.GroupTemplatingFunc, groupingFunc GroupingFunc, messageTypeFunc MessageTypeFunc, routingFunc RoutingFunc, roomResolverFunc matrix.RoomResolverFunc) RenderingFunc {
+	return func(payload *alertmanager.Payload, room string) []Notification {
+		targets, alertsByRoom, unrouted := routeAlerts(payload.Alerts, room, routingFunc, roomResolverFunc, groupingFunc)
+		slog.DebugContext(ctx, "Routed alerts", slog.Int("rooms", len(targets)), slog.Int("unrouted", len(unrouted)))
+
+		var notifications []Notification
+		for _, alert := range unrouted {
+			notifications = append(notifications, Notification{
+				Message: matrix.Message{Fingerprint: alert.Fingerprint, GroupKey: payload.GroupKey, Status: alert.Status},
+				Alerts:  amtemplate.Alerts{alert},
+				Error:   errNoRoom,
+			})
+		}
+		for _, target := range targets {
+			roomData := payload.Data
+			roomData.Alerts = alertsByRoom[target.room]
+			roomData.Status = groupStatus(roomData.Alerts)
+			if target.grouped {
+				notifications = append(notifications, renderGroup(ctx, target, payload.GroupKey, &roomData, groupTemplatingFunc, messageTypeFunc))
+			} else {
+				notifications = append(notifications, renderAlerts(ctx, target, payload.GroupKey, &roomData, templatingFunc, messageTypeFunc)...)
+			}
+		}
+		return notifications
+	}
+}
+
+// target is a resolved room, whether it receives grouped notifications, and the room as selected by the routes, which
+// decides the message type of its messages.
+type target struct {
+	room     string
+	grouped  bool
+	selected string
+}
+
+// routeAlerts returns all rooms in the order they were selected, the alerts to deliver into each room, and the alerts
+// which were not routed into any room. The notification mode and message type of a room are decided by the room as
+// selected by the routes, before resolving the room mapping. Comma-separated lists of rooms are decided per room.
+func routeAlerts(alerts amtemplate.Alerts, room string, routingFunc RoutingFunc, roomResolverFunc matrix.RoomResolverFunc, groupingFunc GroupingFunc) ([]target, map[string]amtemplate.Alerts, amtemplate.Alerts) {
+	var targets []target
+	var unrouted amtemplate.Alerts
+	alertsByRoom := make(map[string]amtemplate.Alerts)
+	for _, alert := range alerts {
+		var alertRooms []string
+		for _, selected := range routingFunc(alert, room) {
+			for _, part := range strings.Split(selected, ",") {
+				part = strings.TrimSpace(part)
+				for _, resolved := range roomResolverFunc(part) {
+					if slices.Contains(alertRooms, resolved) {
+						continue
+					}
+					alertRooms = append(alertRooms, resolved)
+					if _, ok := alertsByRoom[resolved]; !ok {
+						targets = append(targets, target{room: resolved, grouped: groupingFunc(part), selected: part})
+					}
+					alertsByRoom[resolved] = append(alertsByRoom[resolved], alert)
+				}
+			}
+		}
+		if len(alertRooms) == 0 {
+			unrouted = append(unrouted, alert)
+		}
+	}
+	return targets, alertsByRoom, unrouted
+}
+
+func groupStatus(alerts amtemplate.Alerts) string {
+	if len(alerts.Firing()) > 0 {
+		return string(model.AlertFiring)
+	}
+	return string(model.AlertResolved)
+}
+
+func renderGroup(ctx context.Context, target target, groupKey string, data *amtemplate.Data, groupTemplatingFunc alertmanager.GroupTemplatingFunc, messageTypeFunc MessageTypeFunc) Notification {
+	rendered, err := groupTemplatingFunc(data)
+	if err == nil {
+		slog.DebugContext(ctx, "Created group message", slog.String("html", rendered.HTML))
+	}
+	return Notification{
+		Message: matrix.Message{
+			Room:        target.room,
+			HTML:        rendered.HTML,
+			Text:        rendered.Text,
+			MessageType: messageType(rendered, messageTypeFunc(target.selected, "")),
+			Mentions:    rendered.Mentions,
+			GroupKey:    groupKey,
+			Status:      data.Status,
+			Labels:      data.CommonLabels,
+			ExternalURL: data.ExternalURL,
+		},
+		Alerts:  data.Alerts,
+		Grouped: true,
+		Error:   err,
+	}
+}
+
+func renderAlerts(ctx context.Context, target target, groupKey string, data *amtemplate.Data, templatingFunc alertmanager.TemplatingFunc, messageTypeFunc MessageTypeFunc) []Notification {
+	var notifications []Notification
+	for _, alert := range data.Alerts {
+		rendered, err := templatingFunc(alert, data)
+		if err == nil {
+			slog.DebugContext(ctx, "Created message", slog.String("html", rendered.HTML))
+		}
+		notifications = append(notifications, Notification{
+			Message: matrix.Message{
+				Room:        target.room,
+				HTML:        rendered.HTML,
+				Text:        rendered.Text,
+				MessageType: messageType(rendered, messageTypeFunc(target.selected, alert.Status)),
+				Mentions:    rendered.Mentions,
+				Fingerprint: alert.Fingerprint,
+				GroupKey:    groupKey,
+				Status:      alert.Status,
+				Labels:      alert.Labels,
+				ExternalURL: data.ExternalURL,
+			},
+			Alerts: amtemplate.Alerts{alert},
+			Error:  err,
+		})
+	}
+	return notifications
+}
+
+// messageType returns the message type set by a named template, falling back to the given message type of the room.
+func messageType(rendered alertmanager.Rendered, roomMessageType string) string {
+	if rendered.MessageType != "" {
+		return rendered.MessageType
+	}
+	return roomMessageType
+}
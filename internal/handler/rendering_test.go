@@ -0,0 +1,111 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateRenderingFunc(t *testing.T) {
+	templateError := errors.New("broken template")
+	renderingFunc := CreateRenderingFunc(t.Context(),
+		func(alert amtemplate.Alert, data *amtemplate.Data) (alertmanager.Rendered, error) {
+			if alert.Fingerprint == "broken" {
+				return alertmanager.Rendered{}, templateError
+			}
+			if alert.Status == "firing" {
+				return alertmanager.Rendered{HTML: alert.Fingerprint, MessageType: config.MessageTypeText}, nil
+			}
+			return alertmanager.Rendered{HTML: alert.Fingerprint}, nil
+		},
+		func(data *amtemplate.Data) (alertmanager.Rendered, error) {
+			return alertmanager.Rendered{HTML: "group"}, nil
+		},
+		func(room string) bool { return room == "grouped" },
+		func(room string, status string) string { return config.MessageTypeNotice },
+		func(alert amtemplate.Alert, room string) []string { return []string{room} },
+		matrix.CreateRoomResolver(config.Matrix{}))
+	payload := &alertmanager.Payload{
+		Data: amtemplate.Data{
+			Alerts: amtemplate.Alerts{
+				{Status: "firing", Fingerprint: "first"},
+				{Status: "resolved", Fingerprint: "broken"},
+			},
+		},
+		GroupKey: "group-key",
+	}
+
+	assert.Equal(t, []Notification{
+		{
+			Message: matrix.Message{Room: "room", HTML: "first", MessageType: config.MessageTypeText, Fingerprint: "first", GroupKey: "group-key", Status: "firing"},
+			Alerts:  amtemplate.Alerts{payload.Alerts[0]},
+		},
+		{
+			Message: matrix.Message{Room: "room", MessageType: config.MessageTypeNotice, Fingerprint: "broken", GroupKey: "group-key", Status: "resolved"},
+			Alerts:  amtemplate.Alerts{payload.Alerts[1]},
+			Error:   templateError,
+		},
+	}, renderingFunc(payload, "room"))
+	assert.Equal(t, []Notification{
+		{
+			Message: matrix.Message{Room: "grouped", HTML: "group", MessageType: config.MessageTypeNotice, GroupKey: "group-key", Status: "firing"},
+			Alerts:  payload.Alerts,
+			Grouped: true,
+		},
+	}, renderingFunc(payload, "grouped"))
+}
+
+func TestRouteAlerts(t *testing.T) {
+	alerts := amtemplate.Alerts{
+		{Fingerprint: "first", Labels: amtemplate.KV{"team": "db"}},
+		{Fingerprint: "second", Labels: amtemplate.KV{"team": "web"}},
+	}
+	routingFunc := func(alert amtemplate.Alert, room string) []string {
+		if alert.Labels["team"] == "db" {
+			return []string{"db", "noc"}
+		}
+		return []string{room}
+	}
+	roomResolverFunc := matrix.CreateRoomResolver(config.Matrix{
+		RoomMapping: map[string]config.RoomList{
+			"db":  {"!db:example.com", "!noc:example.com"},
+			"noc": {"!noc:example.com"},
+		},
+	})
+	groupingFunc := func(room string) bool { return room == "db" || room == "!web:example.com" }
+
+	targets, alertsByRoom, unrouted := routeAlerts(alerts, "fallback, !web:example.com", routingFunc, roomResolverFunc, groupingFunc)
+
+	assert.Equal(t, []target{
+		{room: "!db:example.com", grouped: true, selected: "db"},
+		{room: "!noc:example.com", grouped: true, selected: "db"},
+		{room: "fallback", grouped: false, selected: "fallback"},
+		{room: "!web:example.com", grouped: true, selected: "!web:example.com"},
+	}, targets)
+	assert.Empty(t, unrouted)
+	assert.Equal(t, amtemplate.Alerts{alerts[0]}, alertsByRoom["!db:example.com"])
+	assert.Equal(t, amtemplate.Alerts{alerts[0]}, alertsByRoom["!noc:example.com"])
+	assert.Equal(t, amtemplate.Alerts{alerts[1]}, alertsByRoom["fallback"])
+	assert.Equal(t, amtemplate.Alerts{alerts[1]}, alertsByRoom["!web:example.com"])
+}
+
+func TestRouteAlerts_NoRoom(t *testing.T) {
+	alerts := amtemplate.Alerts{{Fingerprint: "first"}}
+	routingFunc := func(alert amtemplate.Alert, room string) []string { return []string{room} }
+	roomResolverFunc := matrix.CreateRoomResolver(config.Matrix{})
+
+	targets, _, unrouted := routeAlerts(alerts, "", routingFunc, roomResolverFunc, func(string) bool { return false })
+
+	assert.Empty(t, targets)
+	assert.Equal(t, alerts, unrouted)
+}
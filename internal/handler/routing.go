@@ -0,0 +1,73 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"slices"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	amtemplate "github.com/prometheus/alertmanager/template"
+)
+
+// RoutingFunc selects the rooms for an alert. The given room extracted from the request path is used in case
+// no route matches.
+type RoutingFunc func(alert amtemplate.Alert, room string) []string
+
+type route struct {
+	matchers labels.Matchers
+	rooms    []string
+	cont     bool
+}
+
+func CreateRoutingFunc(routes []config.Route) (RoutingFunc, error) {
+	var parsedRoutes []route
+	for _, r := range routes {
+		matchers, err := config.ParseMatchers(r.Matchers)
+		if err != nil {
+			return nil, err
+		}
+		parsedRoutes = append(parsedRoutes, route{
+			matchers: matchers,
+			rooms:    r.Rooms,
+			cont:     r.Continue,
+		})
+	}
+	return func(alert amtemplate.Alert, room string) []string {
+		var rooms []string
+		for _, r := range parsedRoutes {
+			if !matchesLabels(r.matchers, alert.Labels) {
+				continue
+			}
+			rooms = appendMissing(rooms, r.rooms...)
+			if !r.cont {
+				break
+			}
+		}
+		if len(rooms) == 0 {
+			return []string{room}
+		}
+		return rooms
+	}, nil
+}
+
+func matchesLabels(matchers labels.Matchers, alertLabels amtemplate.KV) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(alertLabels[matcher.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func appendMissing(values []string, candidates ...string) []string {
+	for _, candidate := range candidates {
+		if !slices.Contains(values, candidate) {
+			values = append(values, candidate)
+		}
+	}
+	return values
+}
@@ -0,0 +1,73 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package handler
+
+import (
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingFunc(t *testing.T) {
+	routes := []config.Route{
+		{
+			Matchers: []string{`team="db"`, `severity=~"critical|warning"`},
+			Rooms:    []string{"db"},
+			Continue: true,
+		},
+		{
+			Matchers: []string{`severity="critical"`},
+			Rooms:    []string{"noc", "db"},
+		},
+		{
+			Matchers: []string{`severity!="info"`, `team!~"db|web"`},
+			Rooms:    []string{"others"},
+		},
+	}
+	testCases := map[string]struct {
+		labels   amtemplate.KV
+		expected []string
+	}{
+		"continue": {
+			labels:   amtemplate.KV{"team": "db", "severity": "critical"},
+			expected: []string{"db", "noc"},
+		},
+		"first-only": {
+			labels:   amtemplate.KV{"team": "db", "severity": "warning"},
+			expected: []string{"db"},
+		},
+		"second-only": {
+			labels:   amtemplate.KV{"team": "web", "severity": "critical"},
+			expected: []string{"noc", "db"},
+		},
+		"negative-matchers": {
+			labels:   amtemplate.KV{"team": "infra", "severity": "warning"},
+			expected: []string{"others"},
+		},
+		"missing-label": {
+			labels:   amtemplate.KV{"severity": "warning"},
+			expected: []string{"others"},
+		},
+		"fallback": {
+			labels:   amtemplate.KV{"team": "web", "severity": "info"},
+			expected: []string{"path-room"},
+		},
+	}
+	routingFunc, err := CreateRoutingFunc(routes)
+	assert.NoError(t, err)
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, routingFunc(amtemplate.Alert{Labels: testCase.labels}, "path-room"))
+		})
+	}
+}
+
+func TestRoutingFunc_InvalidMatcher(t *testing.T) {
+	_, err := CreateRoutingFunc([]config.Route{{Matchers: []string{`team=~"(`}, Rooms: []string{"db"}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,193 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	acknowledgementsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_acknowledgements_total",
+		Help: "The total number of alerts acknowledged in Matrix",
+	})
+	suppressedRepeatsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_suppressed_repeats_total",
+		Help: "The total number of suppressed repeated notifications of acknowledged alerts",
+	})
+)
+
+// acknowledgementKey identifies the alert of a message, or its group in case of grouped notifications.
+func acknowledgementKey(fingerprint string, groupKey string) string {
+	if fingerprint != "" {
+		return fingerprint
+	}
+	return groupKey
+}
+
+// activeAcknowledgement returns the acknowledgement of the given alert unless it is older than the notification
+// retention.
+func activeAcknowledgement(configuration config.Matrix, store *state.Store, key string, now time.Time) (state.Acknowledgement, bool) {
+	acknowledgement, ok := store.Acknowledgement(key)
+	if !ok || acknowledgement.AcknowledgedAt.Before(now.Add(-time.Duration(configuration.NotificationRetention))) {
+		return state.Acknowledgement{}, false
+	}
+	return acknowledgement, true
+}
+
+// handleAcknowledgementReaction acknowledges the alerts of a notification once a user reacts to it with the configured
+// reaction.
+func handleAcknowledgementReaction(ctx context.Context, matrixClient *mautrix.Client, current *interactions, evt *event.Event) {
+	acknowledgement := current.configuration.Acknowledgement
+	relation := evt.Content.AsReaction().RelatesTo
+	if !acknowledgement.Enabled || relation.Type != event.RelAnnotation || !sameReaction(relation.Key, acknowledgement.Reaction) {
+		return
+	}
+	if notification, ok := current.store.Notification(evt.RoomID.String(), relation.EventID.String()); ok {
+		acknowledge(ctx, matrixClient, current, notification, evt.Sender)
+	}
+}
+
+// handleAcknowledgementReply acknowledges the alerts of a notification once a user replies to it with the configured
+// reply, either directly or in the thread of the notification.
+func handleAcknowledgementReply(ctx context.Context, matrixClient *mautrix.Client, current *interactions, evt *event.Event) {
+	acknowledgement := current.configuration.Acknowledgement
+	content := *evt.Content.AsMessage()
+	if !acknowledgement.Enabled || content.MsgType != event.MsgText || content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+	content.RemoveReplyFallback()
+	if !strings.EqualFold(strings.TrimSpace(content.Body), acknowledgement.Reply) {
+		return
+	}
+	for _, eventID := range []id.EventID{content.RelatesTo.GetReplyTo(), content.RelatesTo.GetThreadParent()} {
+		if notification, ok := current.store.Notification(evt.RoomID.String(), eventID.String()); ok && eventID != "" {
+			acknowledge(ctx, matrixClient, current, notification, evt.Sender)
+			return
+		}
+	}
+}
+
+// acknowledge records that the given user acknowledged the alerts of a notification and edits the notification to
+// show who acknowledged them. Alerts which are acknowledged already are not acknowledged again, and users below the
+// required power level cannot acknowledge alerts.
+func acknowledge(ctx context.Context, matrixClient *mautrix.Client, current *interactions, notification state.Notification, user id.UserID) {
+	key := acknowledgementKey(notification.Fingerprint, notification.GroupKey)
+	now := time.Now()
+	if existing, ok := activeAcknowledgement(current.configuration, current.store, key, now); ok {
+		slog.DebugContext(ctx, "Alert acknowledged already", slog.String("key", key), slog.String("acknowledged-by", existing.AcknowledgedBy))
+		return
+	}
+	level, err := powerLevel(ctx, matrixClient, id.RoomID(notification.Room), user)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not check power level of acknowledging user", slog.String("user", user.String()), slog.Any("error", err))
+		return
+	}
+	if required := current.configuration.Acknowledgement.RequiredPowerLevel(); level < required {
+		slog.InfoContext(ctx, "Ignoring acknowledgement of user below required power level",
+			slog.String("user", user.String()), slog.Int("power-level", level), slog.Int("required", required))
+		return
+	}
+	err = current.store.SaveAcknowledgement(state.Acknowledgement{
+		Key:            key,
+		AcknowledgedBy: user.String(),
+		AcknowledgedAt: now,
+	}, now.Add(-time.Duration(current.configuration.NotificationRetention)))
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not record acknowledgement", slog.Any("error", err))
+		return
+	}
+	acknowledgementsTotal.Inc()
+	slog.InfoContext(ctx, "Alert acknowledged", slog.String("key", key), slog.String("acknowledged-by", user.String()))
+
+	roomID := id.RoomID(notification.Room)
+	eventID := id.EventID(notification.EventID)
+	original, err := fetchMessage(ctx, matrixClient, roomID, eventID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not fetch acknowledged notification", slog.String("event-id", notification.EventID), slog.Any("error", err))
+		return
+	}
+	content := acknowledgedContent(original, eventID, user)
+	sendNotice(ctx, matrixClient, roomID, content)
+}
+
+// fetchMessage returns the content of the given message, which is decrypted in case it was sent into an encrypted
+// room.
+func fetchMessage(ctx context.Context, matrixClient *mautrix.Client, roomID id.RoomID, eventID id.EventID) (*event.MessageEventContent, error) {
+	evt, err := matrixClient.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if err := evt.Content.ParseRaw(evt.Type); err != nil && !errors.Is(err, event.ErrContentAlreadyParsed) {
+		return nil, err
+	}
+	if evt.Type == event.EventEncrypted {
+		if matrixClient.Crypto == nil {
+			return nil, errors.New("cannot decrypt message without end-to-end encryption enabled")
+		}
+		if evt, err = matrixClient.Crypto.Decrypt(ctx, evt); err != nil {
+			return nil, err
+		}
+	}
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	if !ok {
+		return nil, fmt.Errorf("event %s is not a message", eventID)
+	}
+	return content, nil
+}
+
+// acknowledgedContent returns an edit of the original message which shows who acknowledged it.
+func acknowledgedContent(original *event.MessageEventContent, eventID id.EventID, user id.UserID) *event.MessageEventContent {
+	line := fmt.Sprintf("Acked by %s", user)
+	content := &event.MessageEventContent{
+		MsgType: original.MsgType,
+		Body:    original.Body + "\n\n" + line,
+	}
+	if original.Format == event.FormatHTML {
+		content.Format = event.FormatHTML
+		content.FormattedBody = original.FormattedBody + "<p>" + html.EscapeString(line) + "</p>"
+	}
+	content.SetEdit(eventID)
+	return content
+}
+
+// repeatOfAcknowledged returns the message to send for a repeated notification of an acknowledged alert, which is
+// shortened or suppressed entirely depending on the configuration. Other messages are returned unchanged.
+func repeatOfAcknowledged(configuration config.Matrix, store *state.Store, message Message, now time.Time) (Message, bool) {
+	if !configuration.Acknowledgement.Enabled || message.resolved() {
+		return message, true
+	}
+	acknowledgement, ok := activeAcknowledgement(configuration, store, acknowledgementKey(message.Fingerprint, message.GroupKey), now)
+	if !ok {
+		return message, true
+	}
+	if configuration.Acknowledgement.Repeats == config.RepeatsSuppress {
+		return message, false
+	}
+	name := message.Labels["alertname"]
+	if name == "" {
+		name = "Alert"
+	}
+	text := fmt.Sprintf("%s is still firing, acked by %s", name, acknowledgement.AcknowledgedBy)
+	message.HTML = html.EscapeString(text)
+	message.Text = text
+	message.Mentions = nil
+	return message, true
+}
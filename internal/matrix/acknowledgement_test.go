@@ -0,0 +1,154 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestAcknowledgementKey(t *testing.T) {
+	assert.Equal(t, "fingerprint", acknowledgementKey("fingerprint", "group"))
+	assert.Equal(t, "group", acknowledgementKey("", "group"))
+}
+
+func TestAcknowledgedContent(t *testing.T) {
+	original := &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          "down is firing",
+		Format:        event.FormatHTML,
+		FormattedBody: "<b>down</b> is firing",
+		Mentions:      &event.Mentions{UserIDs: []id.UserID{"@bob:example.com"}},
+	}
+
+	content := acknowledgedContent(original, "$original", "@alice:example.com")
+	assert.Equal(t, "* down is firing\n\nAcked by @alice:example.com", content.Body)
+	assert.Equal(t, "$original", content.RelatesTo.GetReplaceID().String())
+	assert.Equal(t, "down is firing\n\nAcked by @alice:example.com", content.NewContent.Body)
+	assert.Equal(t, "<b>down</b> is firing<p>Acked by @alice:example.com</p>", content.NewContent.FormattedBody)
+	assert.Nil(t, content.NewContent.Mentions)
+
+	plain := acknowledgedContent(&event.MessageEventContent{MsgType: event.MsgNotice, Body: "down"}, "$original", "@alice:example.com")
+	assert.Equal(t, event.MsgNotice, plain.NewContent.MsgType)
+	assert.Empty(t, plain.NewContent.FormattedBody)
+}
+
+func TestHandleAcknowledgementReaction_PowerLevel(t *testing.T) {
+	matrixClient, _ := fakeHomeserver(t, `{"users_default":0,"users":{"@mod:example.com":50}}`)
+	store := mustOpenStore(t)
+	assert.NoError(t, store.SaveNotification(state.Notification{
+		Room:        "!room:example.com",
+		EventID:     "$event",
+		Fingerprint: "first",
+		Labels:      map[string]string{"alertname": "down"},
+		CreatedAt:   time.Now(),
+	}, time.Time{}))
+	current := &interactions{
+		configuration: config.Matrix{
+			Acknowledgement:       config.Acknowledgement{Enabled: true, Reaction: "✅"},
+			NotificationRetention: model.Duration(time.Hour),
+		},
+		store: store,
+	}
+
+	handleAcknowledgementReaction(t.Context(), matrixClient, current, reaction("@alice:example.com", "$event", "✅"))
+	_, ok := store.Acknowledgement("first")
+	assert.False(t, ok)
+
+	handleAcknowledgementReaction(t.Context(), matrixClient, current, reaction("@mod:example.com", "$event", "✅"))
+	acknowledgement, ok := store.Acknowledgement("first")
+	assert.True(t, ok)
+	assert.Equal(t, "@mod:example.com", acknowledgement.AcknowledgedBy)
+}
+
+func TestRepeatOfAcknowledged(t *testing.T) {
+	now := time.Now()
+	store, err := state.OpenStore("")
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveAcknowledgement(state.Acknowledgement{
+		Key:            "fingerprint",
+		AcknowledgedBy: "@alice:example.com",
+		AcknowledgedAt: now.Add(-time.Hour),
+	}, time.Time{}))
+	message := Message{
+		HTML:        "<b>down</b> is firing",
+		Mentions:    &event.Mentions{Room: true},
+		Fingerprint: "fingerprint",
+		Status:      "firing",
+		Labels:      amtemplate.KV{"alertname": "down"},
+	}
+	acknowledgement := config.Acknowledgement{Enabled: true, Reaction: "✅", Reply: "ack"}
+	retention := model.Duration(24 * time.Hour)
+
+	testCases := map[string]struct {
+		configuration config.Matrix
+		message       Message
+		expected      Message
+		send          bool
+	}{
+		"disabled": {
+			configuration: config.Matrix{NotificationRetention: retention},
+			message:       message,
+			expected:      message,
+			send:          true,
+		},
+		"suppress": {
+			configuration: config.Matrix{Acknowledgement: withRepeats(acknowledgement, config.RepeatsSuppress), NotificationRetention: retention},
+			message:       message,
+			expected:      message,
+		},
+		"shorten": {
+			configuration: config.Matrix{Acknowledgement: withRepeats(acknowledgement, config.RepeatsShorten), NotificationRetention: retention},
+			message:       message,
+			expected: Message{
+				HTML:        "down is still firing, acked by @alice:example.com",
+				Text:        "down is still firing, acked by @alice:example.com",
+				Fingerprint: "fingerprint",
+				Status:      "firing",
+				Labels:      amtemplate.KV{"alertname": "down"},
+			},
+			send: true,
+		},
+		"resolved": {
+			configuration: config.Matrix{Acknowledgement: withRepeats(acknowledgement, config.RepeatsSuppress), NotificationRetention: retention},
+			message:       Message{Fingerprint: "fingerprint", Status: "resolved"},
+			expected:      Message{Fingerprint: "fingerprint", Status: "resolved"},
+			send:          true,
+		},
+		"not-acknowledged": {
+			configuration: config.Matrix{Acknowledgement: withRepeats(acknowledgement, config.RepeatsSuppress), NotificationRetention: retention},
+			message:       Message{Fingerprint: "other", Status: "firing"},
+			expected:      Message{Fingerprint: "other", Status: "firing"},
+			send:          true,
+		},
+		"expired": {
+			configuration: config.Matrix{Acknowledgement: withRepeats(acknowledgement, config.RepeatsSuppress), NotificationRetention: model.Duration(time.Minute)},
+			message:       message,
+			expected:      message,
+			send:          true,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			result, send := repeatOfAcknowledged(testCase.configuration, store, testCase.message, now)
+			assert.Equal(t, testCase.send, send)
+			assert.Equal(t, testCase.expected, result)
+		})
+	}
+}
+
+func withRepeats(acknowledgement config.Acknowledgement, repeats string) config.Acknowledgement {
+	acknowledgement.Repeats = repeats
+	return acknowledgement
+}
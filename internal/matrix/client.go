@@ -7,17 +7,21 @@ package matrix
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	amtemplate "github.com/prometheus/alertmanager/template"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
 	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
@@ -42,37 +46,194 @@ var (
 		Name: "matrix_alertmanager_receiver_send_failure_total",
 		Help: "The total number of failed send operations",
 	})
+	resolveAliasSuccessTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_resolve_alias_success_total",
+		Help: "The total number of successful room alias resolutions",
+	})
+	resolveAliasFailureTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_resolve_alias_failure_total",
+		Help: "The total number of failed room alias resolutions",
+	})
 )
 
-type SendingFunc func(htmlText string, roomID string)
+type SendingFunc func(message Message) error
+
+// Message is a rendered notification for a single room. Text is the plain-text version of the message and derived from
+// the HTML if empty. MessageType is the Matrix msgtype of the message and defaults to m.text. Mentions are added to the
+// users mentioned with pills in the HTML. Fingerprint identifies the alert the message was created for and is empty for
+// grouped notifications. GroupKey identifies the Alertmanager group of the notification. Labels are the labels of the
+// alert, or the common labels of all alerts in case of grouped notifications, and ExternalURL is the URL of the
+// Alertmanager which sent them.
+type Message struct {
+	Room        string          `json:"room"`
+	HTML        string          `json:"html"`
+	Text        string          `json:"text,omitempty"`
+	MessageType string          `json:"message-type,omitempty"`
+	Mentions    *event.Mentions `json:"mentions,omitempty"`
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	GroupKey    string          `json:"group-key,omitempty"`
+	Status      string          `json:"status,omitempty"`
+	Labels      amtemplate.KV   `json:"labels,omitempty"`
+	ExternalURL string          `json:"external-url,omitempty"`
+}
+
+// Content returns the Matrix event content of the message.
+func (m Message) Content() event.MessageEventContent {
+	content := format.HTMLToContent(m.HTML)
+	if m.Text != "" {
+		content.Body = m.Text
+	}
+	if m.MessageType != "" {
+		content.MsgType = event.MessageType(m.MessageType)
+	}
+	if m.Mentions != nil {
+		if content.Mentions == nil {
+			content.Mentions = &event.Mentions{}
+		}
+		for _, userID := range m.Mentions.UserIDs {
+			content.Mentions.Add(userID)
+		}
+		content.Mentions.Room = content.Mentions.Room || m.Mentions.Room
+	}
+	return content
+}
+
+func (m Message) resolved() bool {
+	return m.Status == string(model.AlertResolved)
+}
 
-var joinedRoomIDs []string
+var (
+	joinedRoomIDs      []string
+	joinedRoomIDsMutex sync.Mutex
+)
 
-func CreatingSendingFunc(ctx context.Context, configuration config.Matrix) SendingFunc {
-	matrixClient := createMatrixClient(ctx, configuration)
-	fetchJoinedRooms(ctx, matrixClient)
-	return func(htmlText string, room string) {
-		mappedRoom := room
-		if mapped, ok := configuration.RoomMapping[room]; ok {
-			mappedRoom = mapped
+func CreatingSendingFunc(ctx context.Context, configuration config.Matrix, store *state.Store) (SendingFunc, error) {
+	var matrixClient *mautrix.Client
+	var err error
+	if configuration.Encryption.Enabled() {
+		matrixClient, err = sharedSyncingClient(ctx, configuration)
+	} else {
+		matrixClient, err = createMatrixClient(ctx, configuration)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := fetchJoinedRooms(ctx, matrixClient); err != nil {
+		return nil, err
+	}
+	aliases := newAliasCache(aliasCacheTTL(configuration))
+	return func(message Message) error {
+		message, send := repeatOfAcknowledged(configuration, store, message, time.Now())
+		if !send {
+			suppressedRepeatsTotal.Inc()
+			slog.DebugContext(ctx, "Suppressed repeated notification of acknowledged alert", slog.String("room", message.Room))
+			return nil
 		}
-		if err := joinRoom(ctx, matrixClient, mappedRoom); err != nil {
-			joinRoomFailureTotal.WithLabelValues(mappedRoom).Inc()
+		room := message.Room
+		roomID, err := joinRoom(ctx, matrixClient, aliases, room)
+		if err != nil {
+			joinRoomFailureTotal.WithLabelValues(room).Inc()
 			slog.ErrorContext(ctx, fmt.Sprintf("Could not join room %s", room), slog.Any("error", err))
-		} else {
-			joinRoomSuccessTotal.WithLabelValues(mappedRoom).Inc()
-			if respSendEvent, err := matrixClient.SendMessageEvent(ctx, id.RoomID(mappedRoom), event.NewEventType("m.room.message"), format.HTMLToContent(htmlText)); err != nil {
-				sendFailureTotal.Inc()
-				slog.ErrorContext(ctx, "Could not send message to Matrix homeserver", slog.Any("error", err))
-			} else {
-				sendSuccessTotal.Inc()
-				slog.DebugContext(ctx, fmt.Sprintf("Message %s sent to Matrix homeserver", respSendEvent.EventID))
-			}
+			return fmt.Errorf("could not join room %s: %w", room, err)
+		}
+		joinRoomSuccessTotal.WithLabelValues(room).Inc()
+		content := message.Content()
+		key := updateKey(configuration, message)
+		previous, hasPrevious := state.Event{}, false
+		if key != "" {
+			previous, hasPrevious = store.Event(roomID.String(), key)
+			hasPrevious = hasPrevious && !previous.CreatedAt.Before(time.Now().Add(-time.Duration(configuration.UpdateRetention)))
+		}
+		if hasPrevious {
+			relateToPrevious(ctx, configuration, message, &content, id.EventID(previous.EventID))
 		}
+		if err := prepareEncryptedRoom(ctx, matrixClient, roomID); err != nil {
+			sendFailureTotal.Inc()
+			slog.ErrorContext(ctx, "Could not prepare encrypted room", slog.Any("error", err))
+			return err
+		}
+		respSendEvent, err := matrixClient.SendMessageEvent(ctx, roomID, event.NewEventType("m.room.message"), &content)
+		if err != nil {
+			sendFailureTotal.Inc()
+			slog.ErrorContext(ctx, "Could not send message to Matrix homeserver", slog.Any("error", err))
+			return fmt.Errorf("could not send message to room %s: %w", room, err)
+		}
+		sendSuccessTotal.Inc()
+		slog.DebugContext(ctx, fmt.Sprintf("Message %s sent to Matrix homeserver", respSendEvent.EventID))
+		if key != "" {
+			rememberEvent(ctx, configuration, store, roomID.String(), key, message, hasPrevious, respSendEvent.EventID)
+		}
+		if configuration.Interactive() {
+			rememberNotification(ctx, configuration, store, roomID.String(), message, &content, respSendEvent.EventID)
+		}
+		return nil
+	}, nil
+}
+
+// updateKey returns the key used to find previous messages for the same alert, or an empty string in case
+// messages should not refer to each other.
+func updateKey(configuration config.Matrix, message Message) string {
+	switch configuration.UpdateMode {
+	case config.UpdateModeEdit:
+		if message.Fingerprint != "" {
+			return message.Fingerprint
+		}
+		return message.GroupKey
+	case config.UpdateModeThread:
+		if configuration.ThreadKey == config.ThreadKeyGroupKey || message.Fingerprint == "" {
+			return message.GroupKey
+		}
+		return message.Fingerprint
 	}
+	return ""
 }
 
-func createMatrixClient(ctx context.Context, configuration config.Matrix) *mautrix.Client {
+func relateToPrevious(ctx context.Context, configuration config.Matrix, message Message, content *event.MessageEventContent, previous id.EventID) {
+	switch configuration.UpdateMode {
+	case config.UpdateModeEdit:
+		if message.resolved() {
+			slog.DebugContext(ctx, "Editing original message", slog.String("event-id", previous.String()))
+			content.SetEdit(previous)
+		}
+	case config.UpdateModeThread:
+		slog.DebugContext(ctx, "Replying in thread", slog.String("event-id", previous.String()))
+		content.GetRelatesTo().SetThread(previous, previous)
+	}
+}
+
+// rememberEvent records the event of a firing alert so that later messages can refer to it.
+func rememberEvent(ctx context.Context, configuration config.Matrix, store *state.Store, room string, key string, message Message, hasPrevious bool, eventID id.EventID) {
+	var err error
+	switch {
+	case message.resolved() && closesThread(configuration, message):
+		err = store.DeleteEvent(room, key)
+	case message.resolved():
+		// other alerts of the same group might still be firing
+	case configuration.UpdateMode == config.UpdateModeThread && hasPrevious:
+		// keep the thread root
+	default:
+		now := time.Now()
+		err = store.SaveEvent(state.Event{
+			Room:      room,
+			Key:       key,
+			EventID:   eventID.String(),
+			CreatedAt: now,
+		}, now.Add(-time.Duration(configuration.UpdateRetention)))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not update message state", slog.Any("error", err))
+	}
+}
+
+// closesThread returns whether a resolved message concludes all messages sharing its update key. Individual alerts
+// that are threaded by their group key do not, since other alerts of the same group might still be firing.
+func closesThread(configuration config.Matrix, message Message) bool {
+	return configuration.UpdateMode != config.UpdateModeThread ||
+		configuration.ThreadKey != config.ThreadKeyGroupKey ||
+		message.Fingerprint == ""
+}
+
+func createMatrixClient(ctx context.Context, configuration config.Matrix) (*mautrix.Client, error) {
 	var err error
 	var matrixClient *mautrix.Client
 	slog.DebugContext(ctx, "Creating Matrix client", slog.Any("configuration", configuration.LogValue()))
@@ -80,13 +241,11 @@ func createMatrixClient(ctx context.Context, configuration config.Matrix) *mautr
 		// this is similar to mautrix.NewClient but sets a proxy in the http.Client
 		hsURL, err := mautrix.ParseAndNormalizeBaseURL(configuration.HomeServerURL)
 		if err != nil {
-			slog.ErrorContext(ctx, "Failed to parse/normalize base URL", slog.Any("error", err))
-			os.Exit(1)
+			return nil, fmt.Errorf("failed to parse/normalize base URL: %w", err)
 		}
 		proxyUrl, err := url.Parse(configuration.Proxy)
 		if err != nil {
-			slog.ErrorContext(ctx, "Failed to parse proxy URL", slog.Any("error", err))
-			os.Exit(1)
+			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
 		}
 		matrixClient = &mautrix.Client{
 			AccessToken:   configuration.AccessToken,
@@ -100,34 +259,57 @@ func createMatrixClient(ctx context.Context, configuration config.Matrix) *mautr
 		}
 	} else {
 		if matrixClient, err = mautrix.NewClient(configuration.HomeServerURL, id.UserID(configuration.UserID), configuration.AccessToken); err != nil {
-			slog.ErrorContext(ctx, "Failed to create matrix client", slog.Any("error", err))
-			os.Exit(1)
+			return nil, fmt.Errorf("failed to create matrix client: %w", err)
 		}
 	}
 
 	slog.DebugContext(ctx, "Created Matrix client")
-	return matrixClient
+	return matrixClient, nil
 }
 
-func fetchJoinedRooms(ctx context.Context, client *mautrix.Client) {
+func fetchJoinedRooms(ctx context.Context, client *mautrix.Client) error {
 	joinedRooms, err := client.JoinedRooms(ctx)
 	if err != nil {
-		slog.ErrorContext(ctx, "Could not fetch Matrix rooms", slog.Any("error", err))
-		os.Exit(1)
+		return fmt.Errorf("could not fetch Matrix rooms: %w", err)
 	}
+	joinedRoomIDsMutex.Lock()
+	defer joinedRoomIDsMutex.Unlock()
+	joinedRoomIDs = nil
 	for _, roomID := range joinedRooms.JoinedRooms {
 		joinedRoomIDs = append(joinedRoomIDs, roomID.String())
 	}
+	return nil
 }
 
-func joinRoom(ctx context.Context, client *mautrix.Client, roomToJoin string) error {
-	if !slices.Contains(joinedRoomIDs, roomToJoin) {
-		slog.DebugContext(ctx, "Joining room", slog.String("room", roomToJoin))
-		_, err := client.JoinRoomByID(ctx, id.RoomID(roomToJoin))
+// joinRoom joins the given room ID or alias unless already joined and returns the ID of the room.
+func joinRoom(ctx context.Context, client *mautrix.Client, aliases *aliasCache, roomToJoin string) (id.RoomID, error) {
+	roomID, resolveErr := resolveRoom(ctx, client, aliases, roomToJoin)
+	if resolveErr == nil && isJoined(roomID) {
+		return roomID, nil
+	}
+	// the lock is not held while joining, since joins can take long and would block all other messages
+	slog.DebugContext(ctx, "Joining room", slog.String("room", roomToJoin))
+	if isRoomAlias(roomToJoin) {
+		// joining by alias works even if the alias cannot be resolved through the room directory
+		resp, err := client.JoinRoom(ctx, roomToJoin, nil)
 		if err != nil {
-			return err
+			return "", errors.Join(resolveErr, err)
 		}
-		joinedRoomIDs = append(joinedRoomIDs, roomToJoin)
+		aliases.put(roomToJoin, resp.RoomID)
+		roomID = resp.RoomID
+	} else if _, err := client.JoinRoomByID(ctx, roomID); err != nil {
+		return "", err
 	}
-	return nil
+	joinedRoomIDsMutex.Lock()
+	defer joinedRoomIDsMutex.Unlock()
+	if !slices.Contains(joinedRoomIDs, roomID.String()) {
+		joinedRoomIDs = append(joinedRoomIDs, roomID.String())
+	}
+	return roomID, nil
+}
+
+func isJoined(roomID id.RoomID) bool {
+	joinedRoomIDsMutex.Lock()
+	defer joinedRoomIDsMutex.Unlock()
+	return slices.Contains(joinedRoomIDs, roomID.String())
 }
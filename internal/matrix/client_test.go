@@ -0,0 +1,151 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"testing"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestUpdateKey(t *testing.T) {
+	alertMessage := Message{Fingerprint: "fingerprint", GroupKey: "group"}
+	groupMessage := Message{GroupKey: "group"}
+	testCases := map[string]struct {
+		configuration config.Matrix
+		message       Message
+		expected      string
+	}{
+		"new": {
+			configuration: config.Matrix{},
+			message:       alertMessage,
+			expected:      "",
+		},
+		"edit-alert": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeEdit},
+			message:       alertMessage,
+			expected:      "fingerprint",
+		},
+		"edit-group": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeEdit},
+			message:       groupMessage,
+			expected:      "group",
+		},
+		"thread-alert": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeThread},
+			message:       alertMessage,
+			expected:      "fingerprint",
+		},
+		"thread-alert-by-group-key": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeThread, ThreadKey: config.ThreadKeyGroupKey},
+			message:       alertMessage,
+			expected:      "group",
+		},
+		"thread-group": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeThread, ThreadKey: config.ThreadKeyFingerprint},
+			message:       groupMessage,
+			expected:      "group",
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, updateKey(testCase.configuration, testCase.message))
+		})
+	}
+}
+
+func TestClosesThread(t *testing.T) {
+	testCases := map[string]struct {
+		configuration config.Matrix
+		message       Message
+		expected      bool
+	}{
+		"edit": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeEdit},
+			message:       Message{Fingerprint: "fingerprint"},
+			expected:      true,
+		},
+		"thread-by-fingerprint": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeThread},
+			message:       Message{Fingerprint: "fingerprint"},
+			expected:      true,
+		},
+		"thread-alert-by-group-key": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeThread, ThreadKey: config.ThreadKeyGroupKey},
+			message:       Message{Fingerprint: "fingerprint", GroupKey: "group"},
+			expected:      false,
+		},
+		"thread-group-by-group-key": {
+			configuration: config.Matrix{UpdateMode: config.UpdateModeThread, ThreadKey: config.ThreadKeyGroupKey},
+			message:       Message{GroupKey: "group"},
+			expected:      true,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, closesThread(testCase.configuration, testCase.message))
+		})
+	}
+}
+
+func TestMessageContent(t *testing.T) {
+	testCases := map[string]struct {
+		message          Message
+		expectedType     event.MessageType
+		expectedBody     string
+		expectedHTML     string
+		expectedFormat   event.Format
+		expectedMentions *event.Mentions
+	}{
+		"html": {
+			message:        Message{HTML: "<b>down</b>"},
+			expectedType:   event.MsgText,
+			expectedBody:   "**down**",
+			expectedHTML:   "<b>down</b>",
+			expectedFormat: event.FormatHTML,
+		},
+		"text": {
+			message:        Message{HTML: "<b>down</b>", Text: "down"},
+			expectedType:   event.MsgText,
+			expectedBody:   "down",
+			expectedHTML:   "<b>down</b>",
+			expectedFormat: event.FormatHTML,
+		},
+		"mentions": {
+			message: Message{
+				HTML:     `<a href="https://matrix.to/#/@alice:example.com">@alice:example.com</a> @room`,
+				Mentions: &event.Mentions{UserIDs: []id.UserID{"@alice:example.com", "@bob:example.com"}, Room: true},
+			},
+			expectedType:     event.MsgText,
+			expectedBody:     "@alice:example.com @room",
+			expectedHTML:     `<a href="https://matrix.to/#/@alice:example.com">@alice:example.com</a> @room`,
+			expectedFormat:   event.FormatHTML,
+			expectedMentions: &event.Mentions{UserIDs: []id.UserID{"@alice:example.com", "@bob:example.com"}, Room: true},
+		},
+		"notice": {
+			message:        Message{HTML: "<b>down</b>", MessageType: config.MessageTypeNotice},
+			expectedType:   event.MsgNotice,
+			expectedBody:   "**down**",
+			expectedHTML:   "<b>down</b>",
+			expectedFormat: event.FormatHTML,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			content := testCase.message.Content()
+			assert.Equal(t, testCase.expectedType, content.MsgType)
+			assert.Equal(t, testCase.expectedBody, content.Body)
+			assert.Equal(t, testCase.expectedHTML, content.FormattedBody)
+			assert.Equal(t, testCase.expectedFormat, content.Format)
+			if testCase.expectedMentions != nil {
+				assert.Equal(t, testCase.expectedMentions, content.Mentions)
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	commandSuccessTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_command_success_total",
+		Help: "The total number of successful commands",
+	}, []string{"command"})
+	commandFailureTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_command_failure_total",
+		Help: "The total number of failed commands",
+	}, []string{"command"})
+)
+
+// commandFunc runs a command sent into a room with the given arguments and returns the Markdown reply.
+type commandFunc func(ctx context.Context, current *interactions, room string, sender string, arguments string) (string, error)
+
+var commands = map[string]commandFunc{
+	"!alerts":   alertsCommand,
+	"!silence":  silenceCommand,
+	"!silences": silencesCommand,
+	"!expire":   expireCommand,
+}
+
+// handleCommand runs commands sent by users with sufficient power level and replies with their result.
+func handleCommand(ctx context.Context, matrixClient *mautrix.Client, current *interactions, evt *event.Event) {
+	configuration := current.configuration.Commands
+	content := evt.Content.AsMessage()
+	if !configuration.Enabled || content.MsgType != event.MsgText || content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+	name, arguments, _ := strings.Cut(strings.TrimSpace(content.Body), " ")
+	command, ok := commands[name]
+	if !ok {
+		return
+	}
+	slog.DebugContext(ctx, "Received command", slog.String("command", name), slog.String("sender", evt.Sender.String()))
+	level, err := powerLevel(ctx, matrixClient, evt.RoomID, evt.Sender)
+	if err != nil {
+		commandFailureTotal.WithLabelValues(name).Inc()
+		slog.ErrorContext(ctx, "Could not fetch power levels", slog.String("room", evt.RoomID.String()), slog.Any("error", err))
+		reply(ctx, matrixClient, evt, fmt.Sprintf("Could not check your power level: %v", err))
+		return
+	}
+	if required := configuration.RequiredPowerLevel(); level < required {
+		commandFailureTotal.WithLabelValues(name).Inc()
+		reply(ctx, matrixClient, evt, fmt.Sprintf("You need at least power level %d to run commands.", required))
+		return
+	}
+	result, err := command(ctx, current, evt.RoomID.String(), evt.Sender.String(), strings.TrimSpace(arguments))
+	if err != nil {
+		commandFailureTotal.WithLabelValues(name).Inc()
+		slog.ErrorContext(ctx, "Could not run command", slog.String("command", name), slog.Any("error", err))
+		reply(ctx, matrixClient, evt, fmt.Sprintf("Could not run %s: %v", name, err))
+		return
+	}
+	commandSuccessTotal.WithLabelValues(name).Inc()
+	reply(ctx, matrixClient, evt, result)
+}
+
+func powerLevel(ctx context.Context, matrixClient *mautrix.Client, roomID id.RoomID, userID id.UserID) (int, error) {
+	var powerLevels event.PowerLevelsEventContent
+	if err := matrixClient.StateEvent(ctx, roomID, event.StatePowerLevels, "", &powerLevels); err != nil {
+		return 0, err
+	}
+	return powerLevels.GetUserLevel(userID), nil
+}
+
+// reply answers the given event with a notice rendered from Markdown.
+func reply(ctx context.Context, matrixClient *mautrix.Client, evt *event.Event, markdown string) {
+	content := format.RenderMarkdown(markdown, true, false)
+	content.MsgType = event.MsgNotice
+	content.SetReply(evt)
+	sendNotice(ctx, matrixClient, evt.RoomID, &content)
+}
+
+func alertsCommand(ctx context.Context, current *interactions, room string, _ string, _ string) (string, error) {
+	notifications := current.store.Notifications(room)
+	api, err := commandAPI(current, notifications)
+	if err != nil {
+		return "", err
+	}
+	alerts, err := api.Alerts(ctx)
+	if err != nil {
+		return "", err
+	}
+	alerts = roomAlerts(alerts, notifications)
+	if len(alerts) == 0 {
+		return "No alerts are firing in this room.", nil
+	}
+	var lines []string
+	for _, alert := range alerts {
+		lines = append(lines, fmt.Sprintf("- `%s` since %s", formatMatchers(alertmanager.LabelMatchers(alert.Labels)), formatTime(alert.StartsAt)))
+	}
+	return fmt.Sprintf("**Firing alerts (%d)**\n\n%s", len(alerts), strings.Join(lines, "\n")), nil
+}
+
+// roomAlerts returns all alerts which were announced by the given notifications. Alerts of grouped notifications are
+// identified by the common labels of the group.
+func roomAlerts(alerts []alertmanager.Alert, notifications []state.Notification) []alertmanager.Alert {
+	var announced []alertmanager.Alert
+	for _, alert := range alerts {
+		if slices.ContainsFunc(notifications, func(notification state.Notification) bool {
+			if notification.Fingerprint != "" {
+				return notification.Fingerprint == alert.Fingerprint
+			}
+			return len(notification.Labels) > 0 && containsLabels(alert.Labels, notification.Labels)
+		}) {
+			announced = append(announced, alert)
+		}
+	}
+	slices.SortStableFunc(announced, func(first alertmanager.Alert, second alertmanager.Alert) int {
+		return first.StartsAt.Compare(second.StartsAt)
+	})
+	return announced
+}
+
+func containsLabels(labels map[string]string, subset map[string]string) bool {
+	for name, value := range subset {
+		if actual, ok := labels[name]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+func silenceCommand(ctx context.Context, current *interactions, room string, sender string, arguments string) (string, error) {
+	matchers, duration, comment, err := parseSilenceArguments(arguments)
+	if err != nil {
+		return "", err
+	}
+	api, err := commandAPI(current, current.store.Notifications(room))
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	silence := alertmanager.Silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    now.Add(duration),
+		CreatedBy: sender,
+		Comment:   comment,
+	}
+	silenceID, err := api.CreateSilence(ctx, silence)
+	if err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "Silence created", slog.String("silence-id", silenceID), slog.String("created-by", sender))
+	return fmt.Sprintf("Created silence `%s` for `%s` until %s.", silenceID, formatMatchers(matchers), formatTime(silence.EndsAt)), nil
+}
+
+// parseSilenceArguments parses the arguments of the silence command, which are matchers in the Alertmanager syntax
+// followed by a duration and a comment. Matchers may contain spaces, e.g. '{alertname="Disk full"} 2h replacing disk'.
+func parseSilenceArguments(arguments string) ([]alertmanager.Matcher, time.Duration, string, error) {
+	usage := errors.New("usage: !silence <matchers> <duration> <comment>")
+	fields := strings.Fields(arguments)
+	for index := 1; index < len(fields); index++ {
+		duration, err := model.ParseDuration(fields[index])
+		if err != nil {
+			continue
+		}
+		matchers, err := alertmanager.ParseMatchers(strings.Join(fields[:index], " "))
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("invalid matchers: %w", err)
+		}
+		if len(matchers) == 0 {
+			return nil, 0, "", errors.New("at least one matcher is required")
+		}
+		if duration <= 0 {
+			return nil, 0, "", errors.New("the duration must be positive")
+		}
+		comment := strings.Join(fields[index+1:], " ")
+		if comment == "" {
+			return nil, 0, "", usage
+		}
+		return matchers, time.Duration(duration), comment, nil
+	}
+	return nil, 0, "", usage
+}
+
+func silencesCommand(ctx context.Context, current *interactions, room string, _ string, _ string) (string, error) {
+	api, err := commandAPI(current, current.store.Notifications(room))
+	if err != nil {
+		return "", err
+	}
+	silences, err := api.Silences(ctx)
+	if err != nil {
+		return "", err
+	}
+	silences = slices.DeleteFunc(silences, func(silence alertmanager.Silence) bool {
+		return silence.Status == nil || silence.Status.State != alertmanager.SilenceStateActive
+	})
+	if len(silences) == 0 {
+		return "There are no active silences.", nil
+	}
+	slices.SortStableFunc(silences, func(first alertmanager.Silence, second alertmanager.Silence) int {
+		return first.EndsAt.Compare(second.EndsAt)
+	})
+	var lines []string
+	for _, silence := range silences {
+		lines = append(lines, fmt.Sprintf("- `%s` for `%s` until %s by %s: %s",
+			silence.ID, formatMatchers(silence.Matchers), formatTime(silence.EndsAt), silence.CreatedBy, silence.Comment))
+	}
+	return fmt.Sprintf("**Active silences (%d)**\n\n%s", len(silences), strings.Join(lines, "\n")), nil
+}
+
+func expireCommand(ctx context.Context, current *interactions, room string, sender string, arguments string) (string, error) {
+	silenceID := arguments
+	if silenceID == "" || strings.ContainsAny(silenceID, " \t\n") {
+		return "", errors.New("usage: !expire <silence ID>")
+	}
+	api, err := commandAPI(current, current.store.Notifications(room))
+	if err != nil {
+		return "", err
+	}
+	if err := api.ExpireSilence(ctx, silenceID); err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "Silence expired", slog.String("silence-id", silenceID), slog.String("expired-by", sender))
+	return fmt.Sprintf("Expired silence `%s`.", silenceID), nil
+}
+
+// commandAPI returns the API of the configured Alertmanager, falling back to the Alertmanager which sent the latest
+// notification into the room.
+func commandAPI(current *interactions, notifications []state.Notification) (alertmanager.API, error) {
+	var externalURL string
+	for _, notification := range notifications {
+		if notification.ExternalURL != "" {
+			externalURL = notification.ExternalURL
+		}
+	}
+	return alertmanagerAPI(current.alertmanager, externalURL)
+}
+
+func formatMatchers(matchers []alertmanager.Matcher) string {
+	var formatted []string
+	for _, matcher := range matchers {
+		formatted = append(formatted, matcher.String())
+	}
+	return "{" + strings.Join(formatted, ", ") + "}"
+}
+
+func formatTime(timestamp time.Time) string {
+	return timestamp.UTC().Format("2006-01-02 15:04 MST")
+}
@@ -0,0 +1,202 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestParseSilenceArguments(t *testing.T) {
+	testCases := map[string]struct {
+		arguments string
+		matchers  []alertmanager.Matcher
+		duration  time.Duration
+		comment   string
+		hasError  bool
+	}{
+		"single-matcher": {
+			arguments: `alertname="down" 2h replacing disk`,
+			matchers:  []alertmanager.Matcher{{Name: "alertname", Value: "down", IsEqual: true}},
+			duration:  2 * time.Hour,
+			comment:   "replacing disk",
+		},
+		"matchers-with-spaces": {
+			arguments: `{alertname="Disk full", severity=~"critical|warning"} 1d maintenance`,
+			matchers: []alertmanager.Matcher{
+				{Name: "alertname", Value: "Disk full", IsEqual: true},
+				{Name: "severity", Value: "critical|warning", IsRegex: true, IsEqual: true},
+			},
+			duration: 24 * time.Hour,
+			comment:  "maintenance",
+		},
+		"missing-comment": {
+			arguments: `alertname="down" 2h`,
+			hasError:  true,
+		},
+		"missing-duration": {
+			arguments: `alertname="down" replacing disk`,
+			hasError:  true,
+		},
+		"invalid-matchers": {
+			arguments: `alertname=~"[" 2h replacing disk`,
+			hasError:  true,
+		},
+		"empty-matchers": {
+			arguments: `{} 2h replacing disk`,
+			hasError:  true,
+		},
+		"empty": {
+			arguments: ``,
+			hasError:  true,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			matchers, duration, comment, err := parseSilenceArguments(testCase.arguments)
+			if testCase.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, testCase.matchers, matchers)
+			assert.Equal(t, testCase.duration, duration)
+			assert.Equal(t, testCase.comment, comment)
+		})
+	}
+}
+
+func TestRoomAlerts(t *testing.T) {
+	first := alertmanager.Alert{Fingerprint: "first", Labels: amtemplate.KV{"alertname": "down", "team": "db"}, StartsAt: time.Unix(2, 0)}
+	second := alertmanager.Alert{Fingerprint: "second", Labels: amtemplate.KV{"alertname": "slow", "team": "web"}, StartsAt: time.Unix(1, 0)}
+	third := alertmanager.Alert{Fingerprint: "third", Labels: amtemplate.KV{"alertname": "full", "team": "ops"}}
+	notifications := []state.Notification{
+		{Fingerprint: "first", Labels: map[string]string{"alertname": "down", "team": "db"}},
+		{GroupKey: "group", Labels: map[string]string{"team": "web"}},
+		{GroupKey: "everything"},
+	}
+
+	assert.Equal(t, []alertmanager.Alert{second, first}, roomAlerts([]alertmanager.Alert{first, second, third}, notifications))
+}
+
+func TestCommands(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Method == http.MethodGet && request.URL.Path == "/api/v2/alerts":
+			_, _ = writer.Write([]byte(`[
+				{"fingerprint":"first","labels":{"alertname":"down"},"startsAt":"2025-01-01T00:00:00Z"},
+				{"fingerprint":"other","labels":{"alertname":"elsewhere"},"startsAt":"2025-01-01T00:00:00Z"}
+			]`))
+		case request.Method == http.MethodGet && request.URL.Path == "/api/v2/silences":
+			_, _ = writer.Write([]byte(`[
+				{"id":"1234","matchers":[{"name":"alertname","value":"down","isRegex":false,"isEqual":true}],"endsAt":"2025-01-01T02:00:00Z","createdBy":"@alice:example.com","comment":"replacing disk","status":{"state":"active"}},
+				{"id":"5678","matchers":[{"name":"alertname","value":"old","isRegex":false,"isEqual":true}],"status":{"state":"expired"}}
+			]`))
+		case request.Method == http.MethodPost && request.URL.Path == "/api/v2/silences":
+			_, _ = writer.Write([]byte(`{"silenceID":"1234"}`))
+		case request.Method == http.MethodDelete && request.URL.Path == "/api/v2/silence/1234":
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	defer server.Close()
+	store, err := state.OpenStore("")
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveNotification(state.Notification{
+		Room:        "!room:example.com",
+		EventID:     "$event",
+		Fingerprint: "first",
+		Labels:      map[string]string{"alertname": "down"},
+		ExternalURL: server.URL,
+		CreatedAt:   time.Now(),
+	}, time.Time{}))
+	current := &interactions{configuration: config.Matrix{Commands: config.Commands{Enabled: true}}, store: store}
+
+	result, err := alertsCommand(t.Context(), current, "!room:example.com", "@alice:example.com", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "**Firing alerts (1)**\n\n- `{alertname=\"down\"}` since 2025-01-01 00:00 UTC", result)
+
+	result, err = silencesCommand(t.Context(), current, "!room:example.com", "@alice:example.com", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "**Active silences (1)**\n\n- `1234` for `{alertname=\"down\"}` until 2025-01-01 02:00 UTC by @alice:example.com: replacing disk", result)
+
+	result, err = silenceCommand(t.Context(), current, "!room:example.com", "@alice:example.com", `alertname="down" 2h replacing disk`)
+	assert.NoError(t, err)
+	assert.Contains(t, result, "Created silence `1234` for `{alertname=\"down\"}`")
+
+	result, err = expireCommand(t.Context(), current, "!room:example.com", "@alice:example.com", "1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "Expired silence `1234`.", result)
+
+	_, err = expireCommand(t.Context(), current, "!room:example.com", "@alice:example.com", "")
+	assert.Error(t, err)
+	_, err = alertsCommand(t.Context(), current, "!unknown:example.com", "@alice:example.com", "")
+	assert.Error(t, err)
+}
+
+func TestHandleCommand_PowerLevel(t *testing.T) {
+	matrixClient, replies := fakeHomeserver(t, `{"users_default":0,"users":{"@mod:example.com":50}}`)
+	current := &interactions{configuration: config.Matrix{Commands: config.Commands{Enabled: true}}, store: mustOpenStore(t)}
+
+	handleCommand(t.Context(), matrixClient, current, textMessage("@alice:example.com", "!silences"))
+	assert.Len(t, *replies, 1)
+	assert.Contains(t, (*replies)[0].Body, "You need at least power level 50 to run commands.")
+
+	handleCommand(t.Context(), matrixClient, current, textMessage("@mod:example.com", "!silences"))
+	assert.Len(t, *replies, 2)
+	assert.NotContains(t, (*replies)[1].Body, "power level")
+}
+
+// fakeHomeserver returns a client of a homeserver which answers power level requests with the given power levels and
+// records all messages sent into rooms.
+func fakeHomeserver(t *testing.T, powerLevels string) (*mautrix.Client, *[]event.MessageEventContent) {
+	var sent []event.MessageEventContent
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.Method == http.MethodGet && strings.Contains(request.URL.Path, "/state/m.room.power_levels"):
+			_, _ = writer.Write([]byte(powerLevels))
+		case request.Method == http.MethodPut && strings.Contains(request.URL.Path, "/send/m.room.message/"):
+			var content event.MessageEventContent
+			assert.NoError(t, json.NewDecoder(request.Body).Decode(&content))
+			sent = append(sent, content)
+			_, _ = writer.Write([]byte(`{"event_id":"$sent"}`))
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	t.Cleanup(server.Close)
+	matrixClient, err := mautrix.NewClient(server.URL, "@bot:example.com", "secret")
+	assert.NoError(t, err)
+	return matrixClient, &sent
+}
+
+func textMessage(sender id.UserID, body string) *event.Event {
+	return &event.Event{
+		RoomID:  "!room:example.com",
+		Sender:  sender,
+		Type:    event.EventMessage,
+		Content: event.Content{Parsed: &event.MessageEventContent{MsgType: event.MsgText, Body: body}},
+	}
+}
+
+func mustOpenStore(t *testing.T) *state.Store {
+	store, err := state.OpenStore("")
+	assert.NoError(t, err)
+	return store
+}
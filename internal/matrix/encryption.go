@@ -0,0 +1,42 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// prepareEncryptedRoom fetches the encryption state and the members of a room before the first message is sent into
+// it, since the sync might not have seen a room that was joined just now. Messages into encrypted rooms would
+// otherwise be sent unencrypted or without sharing the session with all members.
+func prepareEncryptedRoom(ctx context.Context, matrixClient *mautrix.Client, roomID id.RoomID) error {
+	if matrixClient.Crypto == nil {
+		return nil
+	}
+	fetched, err := matrixClient.StateStore.HasFetchedMembers(ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("could not read state of room %s: %w", roomID, err)
+	}
+	if fetched {
+		return nil
+	}
+	var encryption event.EncryptionEventContent
+	if err := matrixClient.StateEvent(ctx, roomID, event.StateEncryption, "", &encryption); err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return fmt.Errorf("could not fetch encryption state of room %s: %w", roomID, err)
+	}
+	if _, err := matrixClient.Members(ctx, roomID); err != nil {
+		return fmt.Errorf("could not fetch members of room %s: %w", roomID, err)
+	}
+	slog.DebugContext(ctx, "Fetched encryption state and members", slog.String("room", roomID.String()))
+	return nil
+}
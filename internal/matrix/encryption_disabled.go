@@ -0,0 +1,20 @@
+//go:build !goolm
+
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"errors"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"maunium.net/go/mautrix"
+)
+
+func enableEncryption(_ context.Context, _ *mautrix.Client, _ config.Encryption) error {
+	return errors.New("this binary does not support end-to-end encryption, build it with the goolm build tag")
+}
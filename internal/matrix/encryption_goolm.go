@@ -0,0 +1,57 @@
+//go:build goolm
+
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+	_ "modernc.org/sqlite"
+)
+
+// enableEncryption lets the client encrypt messages into rooms with encryption enabled. The keys of the client and the
+// state of all rooms are stored in an SQLite database, which uses a pure Go driver so that static binaries support
+// encryption as well.
+func enableEncryption(ctx context.Context, matrixClient *mautrix.Client, configuration config.Encryption) error {
+	whoami, err := matrixClient.Whoami(ctx)
+	if err != nil {
+		return fmt.Errorf("could not determine device of access token: %w", err)
+	}
+	if whoami.DeviceID == "" {
+		return errors.New("access token is not bound to a device")
+	}
+	matrixClient.DeviceID = whoami.DeviceID
+
+	database, err := dbutil.NewWithDialect(fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate", configuration.Database), "sqlite")
+	if err != nil {
+		return fmt.Errorf("could not open encryption database: %w", err)
+	}
+	helper, err := cryptohelper.NewCryptoHelper(matrixClient, []byte(configuration.PickleKey), database)
+	if err != nil {
+		return err
+	}
+	if err := helper.Init(ctx); err != nil {
+		_ = helper.Close()
+		return err
+	}
+	matrixClient.Crypto = helper
+	go func() {
+		<-ctx.Done()
+		if err := helper.Close(); err != nil {
+			slog.Error("Could not close encryption database", slog.Any("error", err))
+		}
+	}()
+	slog.InfoContext(ctx, "End-to-end encryption enabled", slog.String("device-id", matrixClient.DeviceID.String()))
+	return nil
+}
@@ -0,0 +1,331 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"github.com/prometheus/alertmanager/pkg/labels"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	escalationSuccessTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_escalation_success_total",
+		Help: "The total number of escalation steps taken",
+	}, []string{"action"})
+	escalationFailureTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_escalation_failure_total",
+		Help: "The total number of escalation steps that could not be taken",
+	}, []string{"action"})
+)
+
+type escalationPolicy struct {
+	matchers labels.Matchers
+	onCall   []string
+	steps    []config.EscalationStep
+}
+
+func parseEscalationPolicies(escalation config.Escalation) ([]escalationPolicy, error) {
+	var policies []escalationPolicy
+	for _, policy := range escalation.Policies {
+		matchers, err := config.ParseMatchers(policy.Matchers)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, escalationPolicy{
+			matchers: matchers,
+			onCall:   policy.OnCall,
+			steps:    policy.Steps,
+		})
+	}
+	return policies, nil
+}
+
+func (p escalationPolicy) matches(notificationLabels map[string]string) bool {
+	for _, matcher := range p.matchers {
+		if !matcher.Matches(notificationLabels[matcher.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// users returns the users to notify with the given step. Steps without users of their own notify the on-call users in
+// turn, starting with the first one.
+func (p escalationPolicy) users(step int) []string {
+	if len(p.steps[step].Users) > 0 {
+		return p.steps[step].Users
+	}
+	turn := 0
+	for _, previous := range p.steps[:step] {
+		if previous.Action != config.EscalationActionRoomMention && len(previous.Users) == 0 {
+			turn++
+		}
+	}
+	return []string{p.onCall[turn%len(p.onCall)]}
+}
+
+// dueEscalation is the next step of an escalation policy which is due for an alert announced in a room.
+type dueEscalation struct {
+	notification state.Notification
+	policy       escalationPolicy
+	step         int
+}
+
+// startEscalating takes due escalation steps in the background until the context is done. The interval between two
+// checks follows the current configuration.
+func startEscalating(ctx context.Context, matrixClient *mautrix.Client) {
+	go func() {
+		for {
+			interval := time.Minute
+			if current := currentInteractions.Load(); current != nil && len(current.escalationPolicies) > 0 {
+				interval = time.Duration(current.configuration.Escalation.Interval)
+				escalate(ctx, matrixClient, current, time.Now())
+			}
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func escalate(ctx context.Context, matrixClient *mautrix.Client, current *interactions, now time.Time) {
+	unresolved := make(map[string]unresolvedAlerts)
+	for _, due := range dueEscalations(current, now) {
+		alerts, err := announcedAlerts(ctx, current, due.notification, unresolved)
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "Could not check whether alert is still firing, escalating anyway", slog.String("room", due.notification.Room), slog.Any("error", err))
+		case len(alerts) == 0:
+			slog.InfoContext(ctx, "Alert no longer firing, stopping escalation", slog.String("room", due.notification.Room))
+			forgetResolved(ctx, current.store, due.notification.Fingerprint, due.notification.GroupKey)
+			continue
+		case !slices.ContainsFunc(alerts, func(alert alertmanager.Alert) bool { return !alert.Suppressed() }):
+			slog.DebugContext(ctx, "Alert silenced or inhibited, pausing escalation", slog.String("room", due.notification.Room))
+			continue
+		}
+		action := due.policy.steps[due.step].Action
+		if err := takeEscalationStep(ctx, matrixClient, current, due, now); err != nil {
+			escalationFailureTotal.WithLabelValues(action).Inc()
+			slog.ErrorContext(ctx, "Could not escalate alert", slog.String("room", due.notification.Room), slog.String("action", action), slog.Any("error", err))
+			continue
+		}
+		escalationSuccessTotal.WithLabelValues(action).Inc()
+		slog.InfoContext(ctx, "Alert escalated", slog.String("room", due.notification.Room), slog.String("action", action), slog.Int("step", due.step+1))
+		err = current.store.SaveEscalation(state.Escalation{
+			Room:        due.notification.Room,
+			Key:         acknowledgementKey(due.notification.Fingerprint, due.notification.GroupKey),
+			Steps:       due.step + 1,
+			EscalatedAt: now,
+		}, now.Add(-time.Duration(current.configuration.NotificationRetention)))
+		if err != nil {
+			slog.ErrorContext(ctx, "Could not record escalation", slog.Any("error", err))
+		}
+	}
+}
+
+// dueEscalations returns the escalation steps which are due for unacknowledged alerts. The delay of each step starts
+// with the first notification of an alert in a room, and at most one step per alert and room is due at a time.
+func dueEscalations(current *interactions, now time.Time) []dueEscalation {
+	var due []dueEscalation
+	seen := make(map[string]bool)
+	for _, notification := range current.store.AllNotifications() {
+		key := acknowledgementKey(notification.Fingerprint, notification.GroupKey)
+		if seen[notification.Room+"/"+key] {
+			continue
+		}
+		seen[notification.Room+"/"+key] = true
+		if _, ok := activeAcknowledgement(current.configuration, current.store, key, now); ok {
+			continue
+		}
+		for _, policy := range current.escalationPolicies {
+			if !policy.matches(notification.Labels) {
+				continue
+			}
+			step := 0
+			if escalation, ok := current.store.Escalation(notification.Room, key); ok {
+				step = escalation.Steps
+			}
+			if step < len(policy.steps) && !now.Before(notification.CreatedAt.Add(time.Duration(policy.steps[step].After))) {
+				due = append(due, dueEscalation{notification: notification, policy: policy, step: step})
+			}
+			break
+		}
+	}
+	return due
+}
+
+// unresolvedAlerts are the alerts of an Alertmanager which are not resolved yet, or the error which prevented fetching
+// them.
+type unresolvedAlerts struct {
+	alerts []alertmanager.Alert
+	err    error
+}
+
+// announcedAlerts asks Alertmanager which alerts of a notification are not resolved yet, since resolved alerts are not
+// announced in case Alertmanager does not send resolved notifications. The given map caches the alerts of each
+// Alertmanager during one round of escalations.
+func announcedAlerts(ctx context.Context, current *interactions, notification state.Notification, unresolved map[string]unresolvedAlerts) ([]alertmanager.Alert, error) {
+	cached, ok := unresolved[notification.ExternalURL]
+	if !ok {
+		api, err := alertmanagerAPI(current.alertmanager, notification.ExternalURL)
+		if err != nil {
+			cached.err = err
+		} else {
+			cached.alerts, cached.err = api.UnresolvedAlerts(ctx)
+		}
+		unresolved[notification.ExternalURL] = cached
+	}
+	if cached.err != nil {
+		return nil, cached.err
+	}
+	return roomAlerts(cached.alerts, []state.Notification{notification}), nil
+}
+
+func takeEscalationStep(ctx context.Context, matrixClient *mautrix.Client, current *interactions, due dueEscalation, now time.Time) error {
+	notification := due.notification
+	unacknowledged := model.Duration(now.Sub(notification.CreatedAt).Round(time.Minute))
+	switch action := due.policy.steps[due.step].Action; action {
+	case config.EscalationActionRoomMention:
+		content := escalationContent(notification, unacknowledged, nil)
+		return repostNotification(ctx, matrixClient, current, notification, content, now)
+	case config.EscalationActionMention:
+		content := escalationContent(notification, unacknowledged, due.policy.users(due.step))
+		return repostNotification(ctx, matrixClient, current, notification, content, now)
+	case config.EscalationActionDirectMessage:
+		var errs []error
+		for _, user := range due.policy.users(due.step) {
+			errs = append(errs, sendDirectMessage(ctx, matrixClient, id.UserID(user), directMessageContent(notification, unacknowledged)))
+		}
+		return errors.Join(errs...)
+	default:
+		return fmt.Errorf("unknown escalation action %q", action)
+	}
+}
+
+// escalationContent returns a reply to the notification which mentions the given users, or the entire room in case no
+// users are given.
+func escalationContent(notification state.Notification, unacknowledged model.Duration, users []string) *event.MessageEventContent {
+	text := fmt.Sprintf("%s has not been acknowledged for %s", alertName(notification), unacknowledged)
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Format:   event.FormatHTML,
+		Mentions: &event.Mentions{},
+	}
+	if len(users) == 0 {
+		content.Mentions.Room = true
+		content.Body = "@room " + text
+		content.FormattedBody = "@room " + html.EscapeString(text)
+	} else {
+		var pills []string
+		for _, user := range users {
+			userID := id.UserID(user)
+			content.Mentions.Add(userID)
+			pills = append(pills, fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(userID.URI().MatrixToURL()), html.EscapeString(user)))
+		}
+		content.Body = strings.Join(users, " ") + " " + text
+		content.FormattedBody = strings.Join(pills, " ") + " " + html.EscapeString(text)
+	}
+	if notification.ThreadRoot != "" {
+		content.GetRelatesTo().SetThread(id.EventID(notification.ThreadRoot), id.EventID(notification.EventID))
+	} else {
+		content.GetRelatesTo().SetReplyTo(id.EventID(notification.EventID))
+	}
+	return content
+}
+
+// repostNotification sends the given content into the room of the notification. The new message is recorded as
+// notification of the same alerts, so that users can acknowledge it as well.
+func repostNotification(ctx context.Context, matrixClient *mautrix.Client, current *interactions, notification state.Notification, content *event.MessageEventContent, now time.Time) error {
+	roomID := id.RoomID(notification.Room)
+	if err := prepareEncryptedRoom(ctx, matrixClient, roomID); err != nil {
+		return err
+	}
+	resp, err := matrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+	if err != nil {
+		return err
+	}
+	notification.EventID = resp.EventID.String()
+	notification.ThreadRoot = content.RelatesTo.GetThreadParent().String()
+	notification.CreatedAt = now
+	if err := current.store.SaveNotification(notification, now.Add(-time.Duration(current.configuration.NotificationRetention))); err != nil {
+		slog.ErrorContext(ctx, "Could not record notification", slog.Any("error", err))
+	}
+	return nil
+}
+
+func directMessageContent(notification state.Notification, unacknowledged model.Duration) *event.MessageEventContent {
+	link := id.RoomID(notification.Room).EventURI(id.EventID(notification.EventID)).MatrixToURL()
+	text := fmt.Sprintf("%s has not been acknowledged for %s", alertName(notification), unacknowledged)
+	return &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Body:          fmt.Sprintf("%s: %s", text, link),
+		Format:        event.FormatHTML,
+		FormattedBody: fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(link), html.EscapeString(text)),
+	}
+}
+
+// sendDirectMessage sends the given content into the direct chat with a user, which is created in case there is none
+// yet.
+func sendDirectMessage(ctx context.Context, matrixClient *mautrix.Client, user id.UserID, content *event.MessageEventContent) error {
+	roomID, err := directRoom(ctx, matrixClient, user)
+	if err != nil {
+		return fmt.Errorf("could not find direct chat with %s: %w", user, err)
+	}
+	if err := prepareEncryptedRoom(ctx, matrixClient, roomID); err != nil {
+		return err
+	}
+	_, err = matrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content)
+	return err
+}
+
+func directRoom(ctx context.Context, matrixClient *mautrix.Client, user id.UserID) (id.RoomID, error) {
+	direct := event.DirectChatsEventContent{}
+	if err := matrixClient.GetAccountData(ctx, event.AccountDataDirectChats.Type, &direct); err != nil && !errors.Is(err, mautrix.MNotFound) {
+		return "", err
+	}
+	if rooms := direct[user]; len(rooms) > 0 {
+		return rooms[len(rooms)-1], nil
+	}
+	resp, err := matrixClient.CreateRoom(ctx, &mautrix.ReqCreateRoom{
+		Preset:   "trusted_private_chat",
+		IsDirect: true,
+		Invite:   []id.UserID{user},
+	})
+	if err != nil {
+		return "", err
+	}
+	direct[user] = append(direct[user], resp.RoomID)
+	if err := matrixClient.SetAccountData(ctx, event.AccountDataDirectChats.Type, direct); err != nil {
+		slog.WarnContext(ctx, "Could not remember direct chat", slog.String("user", user.String()), slog.Any("error", err))
+	}
+	return resp.RoomID, nil
+}
+
+func alertName(notification state.Notification) string {
+	if name := notification.Labels["alertname"]; name != "" {
+		return name
+	}
+	return "Alert"
+}
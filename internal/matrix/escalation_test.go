@@ -0,0 +1,173 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestEscalationPolicy_Users(t *testing.T) {
+	policies, err := parseEscalationPolicies(config.Escalation{Policies: []config.EscalationPolicy{{
+		OnCall: config.UserList{"@alice:example.com", "@bob:example.com"},
+		Steps: []config.EscalationStep{
+			{Action: config.EscalationActionMention},
+			{Action: config.EscalationActionRoomMention},
+			{Action: config.EscalationActionDirectMessage},
+			{Action: config.EscalationActionMention, Users: config.UserList{"@carol:example.com"}},
+			{Action: config.EscalationActionMention},
+		},
+	}}})
+	assert.NoError(t, err)
+	policy := policies[0]
+
+	assert.Equal(t, []string{"@alice:example.com"}, policy.users(0))
+	assert.Equal(t, []string{"@bob:example.com"}, policy.users(2))
+	assert.Equal(t, []string{"@carol:example.com"}, policy.users(3))
+	assert.Equal(t, []string{"@alice:example.com"}, policy.users(4))
+}
+
+func TestDueEscalations(t *testing.T) {
+	now := time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC)
+	store, err := state.OpenStore("")
+	assert.NoError(t, err)
+	notifications := []state.Notification{
+		{Room: "!room:example.com", EventID: "$critical", Fingerprint: "critical", Labels: map[string]string{"severity": "critical"}, CreatedAt: now.Add(-20 * time.Minute)},
+		{Room: "!room:example.com", EventID: "$repeated", Fingerprint: "critical", Labels: map[string]string{"severity": "critical"}, CreatedAt: now.Add(-5 * time.Minute)},
+		{Room: "!room:example.com", EventID: "$warning", Fingerprint: "warning", Labels: map[string]string{"severity": "warning"}, CreatedAt: now.Add(-20 * time.Minute)},
+		{Room: "!room:example.com", EventID: "$acknowledged", Fingerprint: "acknowledged", Labels: map[string]string{"severity": "critical"}, CreatedAt: now.Add(-20 * time.Minute)},
+		{Room: "!room:example.com", EventID: "$recent", Fingerprint: "recent", Labels: map[string]string{"severity": "critical"}, CreatedAt: now.Add(-5 * time.Minute)},
+		{Room: "!room:example.com", EventID: "$escalated", Fingerprint: "escalated", Labels: map[string]string{"severity": "critical"}, CreatedAt: now.Add(-20 * time.Minute)},
+		{Room: "!room:example.com", EventID: "$finished", Fingerprint: "finished", Labels: map[string]string{"severity": "critical"}, CreatedAt: now.Add(-time.Hour)},
+	}
+	for _, notification := range notifications {
+		assert.NoError(t, store.SaveNotification(notification, time.Time{}))
+	}
+	assert.NoError(t, store.SaveAcknowledgement(state.Acknowledgement{Key: "acknowledged", AcknowledgedBy: "@alice:example.com", AcknowledgedAt: now}, time.Time{}))
+	assert.NoError(t, store.SaveEscalation(state.Escalation{Room: "!room:example.com", Key: "escalated", Steps: 1, EscalatedAt: now}, time.Time{}))
+	assert.NoError(t, store.SaveEscalation(state.Escalation{Room: "!room:example.com", Key: "finished", Steps: 2, EscalatedAt: now}, time.Time{}))
+	escalation := config.Escalation{Policies: []config.EscalationPolicy{{
+		Matchers: []string{`severity="critical"`},
+		Steps: []config.EscalationStep{
+			{After: model.Duration(15 * time.Minute), Action: config.EscalationActionRoomMention},
+			{After: model.Duration(30 * time.Minute), Action: config.EscalationActionRoomMention},
+		},
+	}}}
+	policies, err := parseEscalationPolicies(escalation)
+	assert.NoError(t, err)
+	current := &interactions{
+		configuration:      config.Matrix{Escalation: escalation, NotificationRetention: model.Duration(24 * time.Hour)},
+		store:              store,
+		escalationPolicies: policies,
+	}
+
+	assert.Equal(t, map[string]int{"$critical": 0}, dueSteps(dueEscalations(current, now)))
+	assert.Equal(t, map[string]int{"$critical": 0, "$recent": 0, "$escalated": 1}, dueSteps(dueEscalations(current, now.Add(15*time.Minute))))
+}
+
+func TestEscalate_Alertmanager(t *testing.T) {
+	now := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet && request.URL.Path == "/api/v2/alerts" {
+			_, _ = writer.Write([]byte(`[
+				{"fingerprint":"firing","labels":{"severity":"critical"},"status":{"state":"active"}},
+				{"fingerprint":"silenced","labels":{"severity":"critical"},"status":{"state":"suppressed"}}
+			]`))
+			return
+		}
+		http.NotFound(writer, request)
+	}))
+	defer server.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	matrixClient, sent := fakeHomeserver(t, `{}`)
+	store := mustOpenStore(t)
+	for fingerprint, externalURL := range map[string]string{"firing": server.URL, "silenced": server.URL, "resolved": server.URL, "unknown": unreachable.URL} {
+		assert.NoError(t, store.SaveNotification(state.Notification{
+			Room:        "!room:example.com",
+			EventID:     "$" + fingerprint,
+			Fingerprint: fingerprint,
+			Labels:      map[string]string{"severity": "critical"},
+			ExternalURL: externalURL,
+			CreatedAt:   now.Add(-20 * time.Minute),
+		}, time.Time{}))
+	}
+	assert.NoError(t, store.SaveEscalation(state.Escalation{Room: "!room:example.com", Key: "resolved", Steps: 1, EscalatedAt: now}, time.Time{}))
+	escalation := config.Escalation{Policies: []config.EscalationPolicy{{
+		Matchers: []string{`severity="critical"`},
+		Steps: []config.EscalationStep{
+			{After: model.Duration(15 * time.Minute), Action: config.EscalationActionRoomMention},
+			{After: model.Duration(15 * time.Minute), Action: config.EscalationActionRoomMention},
+		},
+	}}}
+	policies, err := parseEscalationPolicies(escalation)
+	assert.NoError(t, err)
+	current := &interactions{
+		configuration:      config.Matrix{Escalation: escalation, NotificationRetention: model.Duration(24 * time.Hour)},
+		store:              store,
+		escalationPolicies: policies,
+	}
+
+	escalate(t.Context(), matrixClient, current, now)
+
+	var repliedTo []id.EventID
+	for _, content := range *sent {
+		repliedTo = append(repliedTo, content.RelatesTo.GetReplyTo())
+	}
+	assert.ElementsMatch(t, []id.EventID{"$firing", "$unknown"}, repliedTo)
+	_, ok := store.Escalation("!room:example.com", "silenced")
+	assert.False(t, ok)
+	_, ok = store.Notification("!room:example.com", "$silenced")
+	assert.True(t, ok)
+	_, ok = store.Escalation("!room:example.com", "resolved")
+	assert.False(t, ok)
+	_, ok = store.Notification("!room:example.com", "$resolved")
+	assert.False(t, ok)
+}
+
+func dueSteps(due []dueEscalation) map[string]int {
+	steps := make(map[string]int)
+	for _, escalation := range due {
+		steps[escalation.notification.EventID] = escalation.step
+	}
+	return steps
+}
+
+func TestEscalationContent(t *testing.T) {
+	notification := state.Notification{
+		Room:        "!room:example.com",
+		EventID:     "$event",
+		Fingerprint: "fingerprint",
+		Labels:      map[string]string{"alertname": "down"},
+	}
+	unacknowledged := model.Duration(15 * time.Minute)
+
+	room := escalationContent(notification, unacknowledged, nil)
+	assert.Equal(t, "@room down has not been acknowledged for 15m", room.Body)
+	assert.True(t, room.Mentions.Room)
+	assert.Equal(t, id.EventID("$event"), room.RelatesTo.GetReplyTo())
+
+	users := escalationContent(notification, unacknowledged, []string{"@alice:example.com"})
+	assert.Equal(t, "@alice:example.com down has not been acknowledged for 15m", users.Body)
+	assert.Equal(t, `<a href="https://matrix.to/#/@alice:example.com">@alice:example.com</a> down has not been acknowledged for 15m`, users.FormattedBody)
+	assert.Equal(t, []id.UserID{"@alice:example.com"}, users.Mentions.UserIDs)
+	assert.False(t, users.Mentions.Room)
+
+	notification.ThreadRoot = "$root"
+	threaded := escalationContent(notification, unacknowledged, nil)
+	assert.Equal(t, id.EventID("$root"), threaded.RelatesTo.GetThreadParent())
+
+	direct := directMessageContent(notification, unacknowledged)
+	assert.Equal(t, "down has not been acknowledged for 15m: https://matrix.to/#/%21room:example.com/$event", direct.Body)
+}
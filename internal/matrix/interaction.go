@@ -0,0 +1,140 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// interactions contains everything needed to handle events sent by users. It is replaced whenever a configuration is
+// applied, while the event handlers are registered only once.
+type interactions struct {
+	configuration      config.Matrix
+	alertmanager       config.Alertmanager
+	store              *state.Store
+	escalationPolicies []escalationPolicy
+}
+
+var currentInteractions atomic.Pointer[interactions]
+
+// EnableInteractions lets users interact with this receiver, e.g. silence alerts by reacting to notifications or run
+// commands. Interactions are disabled in case the configuration does not enable any of them.
+func EnableInteractions(ctx context.Context, configuration *config.Configuration, store *state.Store) error {
+	if !configuration.Matrix.Interactive() {
+		currentInteractions.Store(nil)
+		return nil
+	}
+	escalationPolicies, err := parseEscalationPolicies(configuration.Matrix.Escalation)
+	if err != nil {
+		return err
+	}
+	if _, err := sharedSyncingClient(ctx, configuration.Matrix); err != nil {
+		return err
+	}
+	currentInteractions.Store(&interactions{
+		configuration:      configuration.Matrix,
+		alertmanager:       configuration.Alertmanager,
+		store:              store,
+		escalationPolicies: escalationPolicies,
+	})
+	slog.InfoContext(ctx, "Interactions enabled")
+	return nil
+}
+
+// registerEventHandlers handles events sent by users of the rooms this receiver has joined. Events sent before the
+// handlers were registered are ignored, since they might have been handled before a restart already.
+func registerEventHandlers(matrixClient *mautrix.Client) {
+	since := time.Now()
+	handle := func(handler func(ctx context.Context, matrixClient *mautrix.Client, current *interactions, evt *event.Event)) mautrix.EventHandler {
+		return func(ctx context.Context, evt *event.Event) {
+			current := currentInteractions.Load()
+			if current == nil || evt.Sender == matrixClient.UserID || time.UnixMilli(evt.Timestamp).Before(since) {
+				return
+			}
+			handler(ctx, matrixClient, current, evt)
+		}
+	}
+	syncer := matrixClient.Syncer.(mautrix.ExtensibleSyncer)
+	syncer.OnEventType(event.EventReaction, handle(handleSilenceReaction))
+	syncer.OnEventType(event.EventReaction, handle(handleAcknowledgementReaction))
+	syncer.OnEventType(event.EventMessage, handle(handleCommand))
+	syncer.OnEventType(event.EventMessage, handle(handleAcknowledgementReply))
+}
+
+// rememberNotification records the alerts announced by a message so that users can interact with them. Edits of
+// previous messages are not recorded, while resolved alerts forget all previous notifications and acknowledgements.
+func rememberNotification(ctx context.Context, configuration config.Matrix, store *state.Store, room string, message Message, content *event.MessageEventContent, eventID id.EventID) {
+	if message.resolved() {
+		forgetResolved(ctx, store, message.Fingerprint, message.GroupKey)
+		return
+	}
+	if content.RelatesTo.GetReplaceID() != "" {
+		return
+	}
+	now := time.Now()
+	err := store.SaveNotification(state.Notification{
+		Room:        room,
+		EventID:     eventID.String(),
+		ThreadRoot:  content.RelatesTo.GetThreadParent().String(),
+		Fingerprint: message.Fingerprint,
+		GroupKey:    message.GroupKey,
+		Labels:      message.Labels,
+		ExternalURL: message.ExternalURL,
+		CreatedAt:   now,
+	}, now.Add(-time.Duration(configuration.NotificationRetention)))
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not record notification", slog.Any("error", err))
+	}
+}
+
+// replyInThread sends the given text as notice into the thread of a notification.
+func replyInThread(ctx context.Context, matrixClient *mautrix.Client, notification state.Notification, text string) {
+	root := notification.ThreadRoot
+	if root == "" {
+		root = notification.EventID
+	}
+	content := event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    text,
+	}
+	content.GetRelatesTo().SetThread(id.EventID(root), id.EventID(notification.EventID))
+	sendNotice(ctx, matrixClient, id.RoomID(notification.Room), &content)
+}
+
+// sendNotice sends a notice answering an interaction of a user. Failures are logged only, since there is nobody to
+// report them to.
+func sendNotice(ctx context.Context, matrixClient *mautrix.Client, roomID id.RoomID, content *event.MessageEventContent) {
+	if err := prepareEncryptedRoom(ctx, matrixClient, roomID); err != nil {
+		slog.ErrorContext(ctx, "Could not prepare encrypted room", slog.Any("error", err))
+		return
+	}
+	if _, err := matrixClient.SendMessageEvent(ctx, roomID, event.EventMessage, content); err != nil {
+		slog.ErrorContext(ctx, "Could not send notice", slog.String("room", roomID.String()), slog.Any("error", err))
+	}
+}
+
+// forgetResolved removes the notifications, the acknowledgement, and the escalations of a resolved alert.
+func forgetResolved(ctx context.Context, store *state.Store, fingerprint string, groupKey string) {
+	key := acknowledgementKey(fingerprint, groupKey)
+	if err := store.DeleteAcknowledgement(key); err != nil {
+		slog.ErrorContext(ctx, "Could not forget acknowledgement", slog.Any("error", err))
+	}
+	if err := store.DeleteEscalations(key); err != nil {
+		slog.ErrorContext(ctx, "Could not forget escalations", slog.Any("error", err))
+	}
+	if err := store.DeleteNotifications(fingerprint, groupKey); err != nil {
+		slog.ErrorContext(ctx, "Could not forget notifications", slog.Any("error", err))
+	}
+}
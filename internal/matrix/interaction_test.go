@@ -0,0 +1,85 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	amtemplate "github.com/prometheus/alertmanager/template"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestRememberNotification(t *testing.T) {
+	configuration := config.Matrix{Silencing: config.Silencing{Reaction: "🔕"}}
+	message := Message{
+		Fingerprint: "fingerprint",
+		GroupKey:    "group",
+		Status:      "firing",
+		Labels:      amtemplate.KV{"alertname": "down"},
+		ExternalURL: "http://alertmanager:9093",
+	}
+	testCases := map[string]struct {
+		message    Message
+		relatesTo  *event.RelatesTo
+		remembered bool
+		threadRoot string
+	}{
+		"firing": {
+			message:    message,
+			remembered: true,
+		},
+		"thread": {
+			message:    message,
+			relatesTo:  (&event.RelatesTo{}).SetThread("$root", "$root"),
+			remembered: true,
+			threadRoot: "$root",
+		},
+		"edit": {
+			message:   message,
+			relatesTo: (&event.RelatesTo{}).SetReplace("$original"),
+		},
+		"resolved": {
+			message: Message{Status: "resolved"},
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			store, err := state.OpenStore("")
+			assert.NoError(t, err)
+			content := event.MessageEventContent{RelatesTo: testCase.relatesTo}
+
+			rememberNotification(t.Context(), configuration, store, "!room:example.com", testCase.message, &content, "$event")
+
+			notification, ok := store.Notification("!room:example.com", "$event")
+			assert.Equal(t, testCase.remembered, ok)
+			if ok {
+				assert.Equal(t, testCase.threadRoot, notification.ThreadRoot)
+				assert.Equal(t, "fingerprint", notification.Fingerprint)
+				assert.Equal(t, map[string]string{"alertname": "down"}, notification.Labels)
+				assert.Equal(t, "http://alertmanager:9093", notification.ExternalURL)
+			}
+		})
+	}
+}
+
+func TestRememberNotification_Resolved(t *testing.T) {
+	configuration := config.Matrix{Acknowledgement: config.Acknowledgement{Enabled: true}}
+	store, err := state.OpenStore("")
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveNotification(state.Notification{Room: "!room:example.com", EventID: "$event", Fingerprint: "fingerprint"}, time.Time{}))
+	assert.NoError(t, store.SaveAcknowledgement(state.Acknowledgement{Key: "fingerprint", AcknowledgedBy: "@alice:example.com"}, time.Time{}))
+
+	rememberNotification(t.Context(), configuration, store, "!room:example.com", Message{Fingerprint: "fingerprint", Status: "resolved"}, &event.MessageEventContent{}, "$resolved")
+
+	_, ok := store.Notification("!room:example.com", "$event")
+	assert.False(t, ok)
+	_, ok = store.Acknowledgement("fingerprint")
+	assert.False(t, ok)
+}
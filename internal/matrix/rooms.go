@@ -0,0 +1,114 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/id"
+)
+
+const defaultAliasCacheTTL = time.Hour
+
+// RoomResolverFunc expands a room as used in URL paths or routes into the rooms to deliver messages into. Multiple
+// rooms can be separated by commas and each of them can be a key of the room mapping.
+type RoomResolverFunc func(room string) []string
+
+func CreateRoomResolver(configuration config.Matrix) RoomResolverFunc {
+	return func(room string) []string {
+		var rooms []string
+		for _, part := range strings.Split(room, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			mapped, ok := configuration.RoomMapping[part]
+			if !ok {
+				mapped = config.RoomList{part}
+			}
+			for _, mappedRoom := range mapped {
+				if !slices.Contains(rooms, mappedRoom) {
+					rooms = append(rooms, mappedRoom)
+				}
+			}
+		}
+		return rooms
+	}
+}
+
+func isRoomAlias(room string) bool {
+	return strings.HasPrefix(room, "#")
+}
+
+// resolveRoom returns the ID of the given room ID or alias. Aliases are resolved through the room directory of the
+// homeserver and cached for the configured time.
+func resolveRoom(ctx context.Context, client *mautrix.Client, aliases *aliasCache, room string) (id.RoomID, error) {
+	if !isRoomAlias(room) {
+		return id.RoomID(room), nil
+	}
+	if roomID, ok := aliases.get(room); ok {
+		return roomID, nil
+	}
+	resp, err := client.ResolveAlias(ctx, id.RoomAlias(room))
+	if err != nil {
+		resolveAliasFailureTotal.Inc()
+		return "", err
+	}
+	resolveAliasSuccessTotal.Inc()
+	aliases.put(room, resp.RoomID)
+	return resp.RoomID, nil
+}
+
+func aliasCacheTTL(configuration config.Matrix) time.Duration {
+	if configuration.AliasCacheTTL > 0 {
+		return time.Duration(configuration.AliasCacheTTL)
+	}
+	return defaultAliasCacheTTL
+}
+
+type cachedAlias struct {
+	roomID  id.RoomID
+	expires time.Time
+}
+
+type aliasCache struct {
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[string]cachedAlias
+}
+
+func newAliasCache(ttl time.Duration) *aliasCache {
+	return &aliasCache{
+		ttl:     ttl,
+		entries: make(map[string]cachedAlias),
+	}
+}
+
+func (c *aliasCache) get(alias string) (id.RoomID, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[alias]
+	if !ok || time.Now().After(entry.expires) {
+		delete(c.entries, alias)
+		return "", false
+	}
+	return entry.roomID, true
+}
+
+func (c *aliasCache) put(alias string, roomID id.RoomID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[alias] = cachedAlias{
+		roomID:  roomID,
+		expires: time.Now().Add(c.ttl),
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestRoomResolver(t *testing.T) {
+	roomResolverFunc := CreateRoomResolver(config.Matrix{
+		RoomMapping: map[string]config.RoomList{
+			"warnings": {"!team:example.com", "!noc:example.com"},
+			"critical": {"!noc:example.com"},
+		},
+	})
+	testCases := map[string]struct {
+		room     string
+		expected []string
+	}{
+		"unmapped": {
+			room:     "!room:example.com",
+			expected: []string{"!room:example.com"},
+		},
+		"mapped-to-multiple": {
+			room:     "warnings",
+			expected: []string{"!team:example.com", "!noc:example.com"},
+		},
+		"comma-separated": {
+			room:     "critical,!room:example.com",
+			expected: []string{"!noc:example.com", "!room:example.com"},
+		},
+		"duplicates": {
+			room:     "warnings,critical",
+			expected: []string{"!team:example.com", "!noc:example.com"},
+		},
+		"empty": {
+			room:     "",
+			expected: nil,
+		},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, roomResolverFunc(testCase.room))
+		})
+	}
+}
+
+func TestAliasCache(t *testing.T) {
+	cache := newAliasCache(time.Hour)
+	_, ok := cache.get("#alerts:example.com")
+	assert.False(t, ok)
+
+	cache.put("#alerts:example.com", "!room:example.com")
+	roomID, ok := cache.get("#alerts:example.com")
+	assert.True(t, ok)
+	assert.Equal(t, id.RoomID("!room:example.com"), roomID)
+
+	expired := newAliasCache(-time.Second)
+	expired.put("#alerts:example.com", "!room:example.com")
+	_, ok = expired.get("#alerts:example.com")
+	assert.False(t, ok)
+}
@@ -0,0 +1,114 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mau.fi/util/variationselector"
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+)
+
+var (
+	silenceSuccessTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_silence_success_total",
+		Help: "The total number of silences created from Matrix",
+	})
+	silenceFailureTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_silence_failure_total",
+		Help: "The total number of silences that could not be created from Matrix",
+	})
+)
+
+// handleSilenceReaction silences the alerts of a notification once a user reacts to it with the configured reaction
+// and replies with the ID of the silence in the thread of the notification.
+func handleSilenceReaction(ctx context.Context, matrixClient *mautrix.Client, current *interactions, evt *event.Event) {
+	silencing := current.configuration.Silencing
+	relation := evt.Content.AsReaction().RelatesTo
+	if !silencing.Enabled() || relation.Type != event.RelAnnotation || !sameReaction(relation.Key, silencing.Reaction) {
+		return
+	}
+	notification, ok := current.store.Notification(evt.RoomID.String(), relation.EventID.String())
+	if !ok {
+		slog.DebugContext(ctx, "Ignoring reaction to unknown notification", slog.String("event-id", relation.EventID.String()))
+		return
+	}
+	level, err := powerLevel(ctx, matrixClient, evt.RoomID, evt.Sender)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not check power level of reacting user", slog.String("user", evt.Sender.String()), slog.Any("error", err))
+		return
+	}
+	if required := silencing.RequiredPowerLevel(); level < required {
+		slog.InfoContext(ctx, "Ignoring silence reaction of user below required power level",
+			slog.String("user", evt.Sender.String()), slog.Int("power-level", level), slog.Int("required", required))
+		return
+	}
+	silence, err := createSilence(ctx, current, notification, evt.Sender.String())
+	if err != nil {
+		silenceFailureTotal.Inc()
+		slog.ErrorContext(ctx, "Could not create silence", slog.String("room", notification.Room), slog.Any("error", err))
+		replyInThread(ctx, matrixClient, notification, fmt.Sprintf("Could not create silence: %v", err))
+		return
+	}
+	silenceSuccessTotal.Inc()
+	slog.InfoContext(ctx, "Silence created", slog.String("silence-id", silence.ID), slog.String("created-by", silence.CreatedBy))
+	replyInThread(ctx, matrixClient, notification, fmt.Sprintf("%s silenced the alert until %s with silence %s",
+		silence.CreatedBy, silence.EndsAt.UTC().Format(time.RFC1123), silence.ID))
+}
+
+func createSilence(ctx context.Context, current *interactions, notification state.Notification, user string) (alertmanager.Silence, error) {
+	silence, err := newSilence(notification, current.configuration.Silencing, user, time.Now())
+	if err != nil {
+		return silence, err
+	}
+	api, err := alertmanagerAPI(current.alertmanager, notification.ExternalURL)
+	if err != nil {
+		return silence, err
+	}
+	silence.ID, err = api.CreateSilence(ctx, silence)
+	return silence, err
+}
+
+// sameReaction compares two reactions while ignoring emoji variation selectors, which clients add inconsistently.
+func sameReaction(first string, second string) bool {
+	return variationselector.Remove(first) == variationselector.Remove(second)
+}
+
+// newSilence returns a silence for the labels of the given notification starting now.
+func newSilence(notification state.Notification, silencing config.Silencing, user string, now time.Time) (alertmanager.Silence, error) {
+	if len(notification.Labels) == 0 {
+		return alertmanager.Silence{}, errors.New("the notification has no labels to silence")
+	}
+	return alertmanager.Silence{
+		Matchers:  alertmanager.LabelMatchers(notification.Labels),
+		StartsAt:  now,
+		EndsAt:    now.Add(time.Duration(silencing.Duration)),
+		CreatedBy: user,
+		Comment:   fmt.Sprintf("Silenced in Matrix by reacting with %s", silencing.Reaction),
+	}, nil
+}
+
+// alertmanagerAPI returns the API of the configured Alertmanager, falling back to the Alertmanager with the given
+// external URL.
+func alertmanagerAPI(configuration config.Alertmanager, externalURL string) (alertmanager.API, error) {
+	if configuration.URL != "" {
+		return alertmanager.NewAPI(configuration.URL), nil
+	}
+	if externalURL != "" {
+		return alertmanager.NewAPI(externalURL), nil
+	}
+	return alertmanager.API{}, errors.New("the URL of Alertmanager is unknown")
+}
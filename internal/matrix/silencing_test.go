@@ -0,0 +1,104 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestNewSilence(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	silencing := config.Silencing{Reaction: "🔕", Duration: model.Duration(2 * time.Hour)}
+
+	silence, err := newSilence(state.Notification{
+		Labels: map[string]string{"alertname": "down", "instance": "example.com"},
+	}, silencing, "@alice:example.com", now)
+	assert.NoError(t, err)
+	assert.Equal(t, alertmanager.Silence{
+		Matchers: []alertmanager.Matcher{
+			{Name: "alertname", Value: "down", IsEqual: true},
+			{Name: "instance", Value: "example.com", IsEqual: true},
+		},
+		StartsAt:  now,
+		EndsAt:    now.Add(2 * time.Hour),
+		CreatedBy: "@alice:example.com",
+		Comment:   "Silenced in Matrix by reacting with 🔕",
+	}, silence)
+
+	_, err = newSilence(state.Notification{}, silencing, "@alice:example.com", now)
+	assert.Error(t, err)
+}
+
+func TestSameReaction(t *testing.T) {
+	assert.True(t, sameReaction("🔕", "🔕"))
+	assert.True(t, sameReaction("✔️", "✔"))
+	assert.False(t, sameReaction("🔕", "👍"))
+}
+
+func TestAlertmanagerAPI(t *testing.T) {
+	_, err := alertmanagerAPI(config.Alertmanager{URL: "http://alertmanager:9093"}, "")
+	assert.NoError(t, err)
+	_, err = alertmanagerAPI(config.Alertmanager{}, "http://alertmanager:9093")
+	assert.NoError(t, err)
+	_, err = alertmanagerAPI(config.Alertmanager{}, "")
+	assert.Error(t, err)
+}
+
+func TestHandleSilenceReaction_PowerLevel(t *testing.T) {
+	silences := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodPost && request.URL.Path == "/api/v2/silences" {
+			silences++
+			_, _ = writer.Write([]byte(`{"silenceID":"1234"}`))
+			return
+		}
+		http.NotFound(writer, request)
+	}))
+	defer server.Close()
+	matrixClient, replies := fakeHomeserver(t, `{"users_default":0,"users":{"@mod:example.com":50}}`)
+	store := mustOpenStore(t)
+	assert.NoError(t, store.SaveNotification(state.Notification{
+		Room:        "!room:example.com",
+		EventID:     "$event",
+		Fingerprint: "first",
+		Labels:      map[string]string{"alertname": "down"},
+		ExternalURL: server.URL,
+		CreatedAt:   time.Now(),
+	}, time.Time{}))
+	current := &interactions{configuration: config.Matrix{Silencing: config.Silencing{Reaction: "🔕", Duration: model.Duration(time.Hour)}}, store: store}
+
+	handleSilenceReaction(t.Context(), matrixClient, current, reaction("@alice:example.com", "$event", "🔕"))
+	assert.Equal(t, 0, silences)
+	assert.Empty(t, *replies)
+
+	handleSilenceReaction(t.Context(), matrixClient, current, reaction("@mod:example.com", "$event", "🔕"))
+	assert.Equal(t, 1, silences)
+	assert.Len(t, *replies, 1)
+}
+
+func reaction(sender id.UserID, target id.EventID, key string) *event.Event {
+	return &event.Event{
+		RoomID: "!room:example.com",
+		Sender: sender,
+		Type:   event.EventReaction,
+		Content: event.Content{Parsed: &event.ReactionEventContent{RelatesTo: event.RelatesTo{
+			Type:    event.RelAnnotation,
+			EventID: target,
+			Key:     key,
+		}}},
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"maunium.net/go/mautrix"
+)
+
+var (
+	syncingClient      *mautrix.Client
+	syncingClientMutex sync.Mutex
+)
+
+// sharedSyncingClient returns the Matrix client which receives events from the homeserver and holds the encryption
+// keys of this receiver. The client is created once and shared by all configurations, since only a single client may
+// use the crypto store at a time and every event must be handled once.
+func sharedSyncingClient(ctx context.Context, configuration config.Matrix) (*mautrix.Client, error) {
+	syncingClientMutex.Lock()
+	defer syncingClientMutex.Unlock()
+	if syncingClient != nil {
+		return syncingClient, nil
+	}
+	matrixClient, err := createMatrixClient(ctx, configuration)
+	if err != nil {
+		return nil, err
+	}
+	if configuration.Encryption.Enabled() {
+		if err := enableEncryption(ctx, matrixClient, configuration.Encryption); err != nil {
+			return nil, fmt.Errorf("could not enable end-to-end encryption: %w", err)
+		}
+	}
+	registerEventHandlers(matrixClient)
+	startSyncing(ctx, matrixClient)
+	startEscalating(ctx, matrixClient)
+	syncingClient = matrixClient
+	return matrixClient, nil
+}
+
+// startSyncing receives events from the Matrix homeserver in the background until the context is done. Failed syncs
+// are retried with an increasing delay.
+func startSyncing(ctx context.Context, matrixClient *mautrix.Client) {
+	go func() {
+		backoff := time.Second
+		for {
+			err := matrixClient.SyncWithContext(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			slog.ErrorContext(ctx, "Syncing with Matrix homeserver failed", slog.Any("error", err), slog.Duration("retry-in", backoff))
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(2*backoff, time.Minute)
+		}
+	}()
+}
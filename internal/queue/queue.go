@@ -0,0 +1,325 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queuePending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_alertmanager_receiver_queue_pending",
+		Help: "The number of messages waiting in the delivery queue",
+	})
+	queueDeliveredTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_queue_delivered_total",
+		Help: "The total number of queued messages delivered to Matrix",
+	})
+	queueRetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_queue_retries_total",
+		Help: "The total number of failed delivery attempts of queued messages that will be retried",
+	})
+	queueExpiredTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_alertmanager_receiver_queue_expired_total",
+		Help: "The total number of queued messages dropped because they exceeded the maximum age",
+	})
+)
+
+const itemSuffix = ".json"
+
+type item struct {
+	ID          string         `json:"id"`
+	Message     matrix.Message `json:"message"`
+	CreatedAt   time.Time      `json:"created-at"`
+	Attempts    int            `json:"attempts"`
+	NextAttempt time.Time      `json:"next-attempt"`
+}
+
+type queue struct {
+	ctx           context.Context
+	configuration config.Queue
+	sendingFunc   matrix.SendingFunc
+	mutex         sync.Mutex
+	pending       map[string]*item
+	wake          chan struct{}
+	jobs          chan *item
+}
+
+// CreateQueuedSendingFunc returns a matrix.SendingFunc that durably writes each message into the configured
+// directory and returns immediately. Background workers deliver queued messages with the given sendingFunc
+// and retry failed deliveries with exponential backoff until they exceed the configured maximum age.
+func CreateQueuedSendingFunc(ctx context.Context, configuration config.Queue, sendingFunc matrix.SendingFunc) (matrix.SendingFunc, error) {
+	slog.DebugContext(ctx, "Creating delivery queue", slog.Any("configuration", configuration.LogValue()))
+	if err := os.MkdirAll(configuration.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create queue directory: %w", err)
+	}
+	q := &queue{
+		ctx:           ctx,
+		configuration: configuration,
+		sendingFunc:   sendingFunc,
+		pending:       make(map[string]*item),
+		wake:          make(chan struct{}, 1),
+		jobs:          make(chan *item),
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Delivery queue loaded", slog.Int("pending", len(q.pending)))
+
+	for range configuration.Workers {
+		go q.work()
+	}
+	go q.dispatch()
+
+	return q.enqueue, nil
+}
+
+func (q *queue) enqueue(message matrix.Message) error {
+	now := time.Now()
+	it := &item{
+		ID:          newItemID(now),
+		Message:     message,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+	if err := q.write(it); err != nil {
+		slog.ErrorContext(q.ctx, "Could not write message into delivery queue", slog.Any("error", err))
+		return fmt.Errorf("could not queue message for room %s: %w", message.Room, err)
+	}
+	slog.DebugContext(q.ctx, "Message queued", slog.String("id", it.ID), slog.String("room", message.Room))
+	q.schedule(it)
+	return nil
+}
+
+func (q *queue) schedule(it *item) {
+	q.mutex.Lock()
+	q.pending[it.ID] = it
+	queuePending.Set(float64(len(q.pending)))
+	q.mutex.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) dispatch() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		due, wait := q.takeDue(time.Now())
+		for _, it := range due {
+			select {
+			case q.jobs <- it:
+			case <-q.ctx.Done():
+				return
+			}
+		}
+		if len(due) > 0 {
+			continue
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-q.wake:
+			timer.Stop()
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// takeDue removes all items whose next attempt is due from the pending set and returns them ordered by their
+// creation time, together with the duration until the next pending item becomes due.
+func (q *queue) takeDue(now time.Time) ([]*item, time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var due []*item
+	wait := time.Duration(q.configuration.MaxBackoff)
+	for id, it := range q.pending {
+		if until := it.NextAttempt.Sub(now); until > 0 {
+			wait = min(wait, until)
+			continue
+		}
+		due = append(due, it)
+		delete(q.pending, id)
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].ID < due[j].ID
+	})
+	queuePending.Set(float64(len(q.pending)))
+	return due, wait
+}
+
+func (q *queue) work() {
+	for {
+		select {
+		case it := <-q.jobs:
+			q.process(it)
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+func (q *queue) process(it *item) {
+	if q.expired(it, time.Now()) {
+		q.drop(it)
+		return
+	}
+	err := q.sendingFunc(it.Message)
+	if err == nil {
+		queueDeliveredTotal.Inc()
+		q.remove(it)
+		return
+	}
+	it.Attempts++
+	now := time.Now()
+	it.NextAttempt = now.Add(q.backoff(it.Attempts))
+	if q.expired(it, it.NextAttempt) {
+		q.drop(it)
+		return
+	}
+	queueRetriesTotal.Inc()
+	slog.WarnContext(q.ctx, "Could not deliver queued message, will retry",
+		slog.String("id", it.ID),
+		slog.String("room", it.Message.Room),
+		slog.Int("attempts", it.Attempts),
+		slog.Time("next-attempt", it.NextAttempt),
+		slog.Any("error", err))
+	if writeErr := q.write(it); writeErr != nil {
+		slog.ErrorContext(q.ctx, "Could not update queued message", slog.String("id", it.ID), slog.Any("error", writeErr))
+	}
+	q.schedule(it)
+}
+
+func (q *queue) expired(it *item, at time.Time) bool {
+	return at.Sub(it.CreatedAt) > time.Duration(q.configuration.MaxAge)
+}
+
+func (q *queue) backoff(attempts int) time.Duration {
+	backoff := time.Duration(q.configuration.InitialBackoff)
+	maxBackoff := time.Duration(q.configuration.MaxBackoff)
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+func (q *queue) drop(it *item) {
+	queueExpiredTotal.Inc()
+	slog.ErrorContext(q.ctx, "Dropping queued message that exceeded the maximum age",
+		slog.String("id", it.ID),
+		slog.String("room", it.Message.Room),
+		slog.Int("attempts", it.Attempts),
+		slog.Time("created-at", it.CreatedAt))
+	q.remove(it)
+}
+
+func (q *queue) remove(it *item) {
+	if err := os.Remove(q.path(it.ID)); err != nil && !os.IsNotExist(err) {
+		slog.ErrorContext(q.ctx, "Could not remove queued message", slog.String("id", it.ID), slog.Any("error", err))
+	}
+}
+
+func (q *queue) load() error {
+	entries, err := os.ReadDir(q.configuration.Directory)
+	if err != nil {
+		return fmt.Errorf("could not read queue directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(name, ".") {
+			// leftover of an interrupted write
+			_ = os.Remove(filepath.Join(q.configuration.Directory, name))
+			continue
+		}
+		if !strings.HasSuffix(name, itemSuffix) {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(q.configuration.Directory, name))
+		if err != nil {
+			return fmt.Errorf("could not read queued message %s: %w", name, err)
+		}
+		var it item
+		if err := json.Unmarshal(content, &it); err != nil {
+			slog.ErrorContext(q.ctx, "Ignoring corrupt queued message", slog.String("file", name), slog.Any("error", err))
+			continue
+		}
+		q.pending[it.ID] = &it
+	}
+	queuePending.Set(float64(len(q.pending)))
+	return nil
+}
+
+// write stores the item atomically by writing a temporary file first and renaming it afterwards.
+func (q *queue) write(it *item) error {
+	content, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(q.configuration.Directory, "."+it.ID+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	if _, err := temp.Write(content); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), q.path(it.ID)); err != nil {
+		return err
+	}
+	return syncDirectory(q.configuration.Directory)
+}
+
+func (q *queue) path(id string) string {
+	return filepath.Join(q.configuration.Directory, id+itemSuffix)
+}
+
+func syncDirectory(directory string) error {
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = dir.Close()
+	}()
+	return dir.Sync()
+}
+
+func newItemID(now time.Time) string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return fmt.Sprintf("%020d-%s", now.UnixNano(), hex.EncodeToString(random))
+}
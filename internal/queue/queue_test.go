@@ -0,0 +1,130 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSender struct {
+	mutex     sync.Mutex
+	failures  int
+	attempts  int
+	delivered []string
+}
+
+func (r *recordingSender) send(message matrix.Message) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.attempts++
+	if r.attempts <= r.failures {
+		return errors.New("homeserver unavailable")
+	}
+	r.delivered = append(r.delivered, message.Room+":"+message.HTML)
+	return nil
+}
+
+func (r *recordingSender) deliveredMessages() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.delivered...)
+}
+
+func testConfiguration(t *testing.T) config.Queue {
+	return config.Queue{
+		Directory:      t.TempDir(),
+		Workers:        1,
+		InitialBackoff: model.Duration(time.Millisecond),
+		MaxBackoff:     model.Duration(10 * time.Millisecond),
+		MaxAge:         model.Duration(time.Minute),
+	}
+}
+
+func TestQueue_RetriesFailedDelivery(t *testing.T) {
+	configuration := testConfiguration(t)
+	sender := &recordingSender{failures: 3}
+	sendingFunc, err := CreateQueuedSendingFunc(t.Context(), configuration, sender.send)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sendingFunc(matrix.Message{Room: "room", HTML: "message"}))
+
+	assert.Eventually(t, func() bool {
+		return len(sender.deliveredMessages()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"room:message"}, sender.deliveredMessages())
+	assert.Eventually(t, func() bool {
+		entries, _ := os.ReadDir(configuration.Directory)
+		return len(entries) == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestQueue_SurvivesRestart(t *testing.T) {
+	configuration := testConfiguration(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	sendingFunc, err := CreateQueuedSendingFunc(ctx, configuration, func(message matrix.Message) error {
+		return errors.New("not running")
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sendingFunc(matrix.Message{Room: "room", HTML: "first"}))
+	assert.NoError(t, sendingFunc(matrix.Message{Room: "room", HTML: "second"}))
+
+	sender := &recordingSender{}
+	_, err = CreateQueuedSendingFunc(t.Context(), configuration, sender.send)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(sender.deliveredMessages()) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"room:first", "room:second"}, sender.deliveredMessages())
+}
+
+func TestQueue_DropsExpiredMessages(t *testing.T) {
+	configuration := testConfiguration(t)
+	configuration.MaxAge = model.Duration(5 * time.Millisecond)
+	sender := &recordingSender{failures: 1000}
+	sendingFunc, err := CreateQueuedSendingFunc(t.Context(), configuration, sender.send)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sendingFunc(matrix.Message{Room: "room", HTML: "message"}))
+
+	assert.Eventually(t, func() bool {
+		entries, _ := os.ReadDir(configuration.Directory)
+		return len(entries) == 0
+	}, time.Second, time.Millisecond)
+	assert.Empty(t, sender.deliveredMessages())
+}
+
+func TestBackoff(t *testing.T) {
+	q := &queue{configuration: config.Queue{
+		InitialBackoff: model.Duration(time.Second),
+		MaxBackoff:     model.Duration(10 * time.Second),
+	}}
+	testCases := map[string]struct {
+		attempts int
+		expected time.Duration
+	}{
+		"first":  {attempts: 1, expected: time.Second},
+		"second": {attempts: 2, expected: 2 * time.Second},
+		"third":  {attempts: 3, expected: 4 * time.Second},
+		"capped": {attempts: 10, expected: 10 * time.Second},
+	}
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, q.backoff(testCase.attempts))
+		})
+	}
+}
@@ -0,0 +1,303 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event links a Matrix event to the alert it was sent for.
+type Event struct {
+	Room      string    `json:"room"`
+	Key       string    `json:"key"`
+	EventID   string    `json:"event-id"`
+	CreatedAt time.Time `json:"created-at"`
+}
+
+// Notification links a Matrix event to the alerts it announced, so that users can interact with them. Labels are the
+// labels of the alert, or the common labels in case of grouped notifications. ThreadRoot is set in case the event was
+// sent into a thread.
+type Notification struct {
+	Room        string            `json:"room"`
+	EventID     string            `json:"event-id"`
+	ThreadRoot  string            `json:"thread-root,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	GroupKey    string            `json:"group-key,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	ExternalURL string            `json:"external-url,omitempty"`
+	CreatedAt   time.Time         `json:"created-at"`
+}
+
+// Acknowledgement records who acknowledged an alert. Key is the fingerprint of the alert, or the group key in case of
+// grouped notifications.
+type Acknowledgement struct {
+	Key            string    `json:"key"`
+	AcknowledgedBy string    `json:"acknowledged-by"`
+	AcknowledgedAt time.Time `json:"acknowledged-at"`
+}
+
+// Escalation records how many steps of an escalation policy were taken for an alert in a room. Key is the fingerprint
+// of the alert, or the group key in case of grouped notifications.
+type Escalation struct {
+	Room        string    `json:"room"`
+	Key         string    `json:"key"`
+	Steps       int       `json:"steps"`
+	EscalatedAt time.Time `json:"escalated-at"`
+}
+
+type storeData struct {
+	Events           map[string]Event           `json:"events"`
+	Notifications    map[string]Notification    `json:"notifications,omitempty"`
+	Acknowledgements map[string]Acknowledgement `json:"acknowledgements,omitempty"`
+	Escalations      map[string]Escalation      `json:"escalations,omitempty"`
+}
+
+// Store keeps state which must survive restarts in a single JSON file. A store without a path keeps its state in
+// memory only.
+type Store struct {
+	path  string
+	mutex sync.RWMutex
+	data  storeData
+}
+
+func OpenStore(path string) (*Store, error) {
+	store := &Store{
+		path: path,
+		data: storeData{
+			Events:           make(map[string]Event),
+			Notifications:    make(map[string]Notification),
+			Acknowledgements: make(map[string]Acknowledgement),
+			Escalations:      make(map[string]Escalation),
+		},
+	}
+	if path == "" {
+		return store, nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read state file: %w", err)
+	}
+	if err := json.Unmarshal(content, &store.data); err != nil {
+		return nil, fmt.Errorf("could not parse state file: %w", err)
+	}
+	if store.data.Events == nil {
+		store.data.Events = make(map[string]Event)
+	}
+	if store.data.Notifications == nil {
+		store.data.Notifications = make(map[string]Notification)
+	}
+	if store.data.Acknowledgements == nil {
+		store.data.Acknowledgements = make(map[string]Acknowledgement)
+	}
+	if store.data.Escalations == nil {
+		store.data.Escalations = make(map[string]Escalation)
+	}
+	return store, nil
+}
+
+func (s *Store) Event(room string, key string) (Event, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	event, ok := s.data.Events[eventKey(room, key)]
+	return event, ok
+}
+
+// SaveEvent records the given event and forgets all events created before the given time.
+func (s *Store) SaveEvent(event Event, forgetBefore time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, existing := range s.data.Events {
+		if existing.CreatedAt.Before(forgetBefore) {
+			delete(s.data.Events, key)
+		}
+	}
+	s.data.Events[eventKey(event.Room, event.Key)] = event
+	return s.persist()
+}
+
+func (s *Store) DeleteEvent(room string, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.data.Events, eventKey(room, key))
+	return s.persist()
+}
+
+func (s *Store) Notification(room string, eventID string) (Notification, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	notification, ok := s.data.Notifications[eventKey(room, eventID)]
+	return notification, ok
+}
+
+// Notifications returns all notifications of the given room ordered by their creation time.
+func (s *Store) Notifications(room string) []Notification {
+	return s.filterNotifications(func(notification Notification) bool {
+		return notification.Room == room
+	})
+}
+
+// AllNotifications returns the notifications of all rooms ordered by their creation time.
+func (s *Store) AllNotifications() []Notification {
+	return s.filterNotifications(func(Notification) bool {
+		return true
+	})
+}
+
+func (s *Store) filterNotifications(keep func(notification Notification) bool) []Notification {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var notifications []Notification
+	for _, notification := range s.data.Notifications {
+		if keep(notification) {
+			notifications = append(notifications, notification)
+		}
+	}
+	slices.SortFunc(notifications, func(first Notification, second Notification) int {
+		return first.CreatedAt.Compare(second.CreatedAt)
+	})
+	return notifications
+}
+
+// SaveNotification records the given notification and forgets all notifications created before the given time.
+func (s *Store) SaveNotification(notification Notification, forgetBefore time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, existing := range s.data.Notifications {
+		if existing.CreatedAt.Before(forgetBefore) {
+			delete(s.data.Notifications, key)
+		}
+	}
+	s.data.Notifications[eventKey(notification.Room, notification.EventID)] = notification
+	return s.persist()
+}
+
+// DeleteNotifications forgets all notifications of the alert with the given fingerprint, or all grouped
+// notifications of the given group in case the fingerprint is empty.
+func (s *Store) DeleteNotifications(fingerprint string, groupKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	deleted := false
+	for key, notification := range s.data.Notifications {
+		if notification.Fingerprint == fingerprint && (fingerprint != "" || notification.GroupKey == groupKey) {
+			delete(s.data.Notifications, key)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	return s.persist()
+}
+
+func (s *Store) Acknowledgement(key string) (Acknowledgement, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	acknowledgement, ok := s.data.Acknowledgements[key]
+	return acknowledgement, ok
+}
+
+// SaveAcknowledgement records the given acknowledgement and forgets all acknowledgements made before the given time.
+func (s *Store) SaveAcknowledgement(acknowledgement Acknowledgement, forgetBefore time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, existing := range s.data.Acknowledgements {
+		if existing.AcknowledgedAt.Before(forgetBefore) {
+			delete(s.data.Acknowledgements, key)
+		}
+	}
+	s.data.Acknowledgements[acknowledgement.Key] = acknowledgement
+	return s.persist()
+}
+
+func (s *Store) DeleteAcknowledgement(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.data.Acknowledgements[key]; !ok {
+		return nil
+	}
+	delete(s.data.Acknowledgements, key)
+	return s.persist()
+}
+
+func (s *Store) Escalation(room string, key string) (Escalation, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	escalation, ok := s.data.Escalations[eventKey(room, key)]
+	return escalation, ok
+}
+
+// SaveEscalation records the given escalation and forgets all escalations last escalated before the given time.
+func (s *Store) SaveEscalation(escalation Escalation, forgetBefore time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, existing := range s.data.Escalations {
+		if existing.EscalatedAt.Before(forgetBefore) {
+			delete(s.data.Escalations, key)
+		}
+	}
+	s.data.Escalations[eventKey(escalation.Room, escalation.Key)] = escalation
+	return s.persist()
+}
+
+// DeleteEscalations forgets the escalations of the given alert in all rooms.
+func (s *Store) DeleteEscalations(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	deleted := false
+	for storeKey, escalation := range s.data.Escalations {
+		if escalation.Key == key {
+			delete(s.data.Escalations, storeKey)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return nil
+	}
+	return s.persist()
+}
+
+func eventKey(room string, key string) string {
+	return room + "/" + key
+}
+
+// persist writes the entire state into a temporary file and renames it afterwards. Callers must hold the lock.
+func (s *Store) persist() error {
+	if s.path == "" {
+		return nil
+	}
+	content, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	if _, err := temp.Write(content); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.path)
+}
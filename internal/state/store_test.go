@@ -0,0 +1,175 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package state
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Events(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+
+	event := Event{
+		Room:      "!room:example.com",
+		Key:       "fingerprint",
+		EventID:   "$event",
+		CreatedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	old := Event{Room: "!room:example.com", Key: "old", EventID: "$old", CreatedAt: event.CreatedAt.Add(-48 * time.Hour)}
+	assert.NoError(t, store.SaveEvent(old, time.Time{}))
+	assert.NoError(t, store.SaveEvent(event, event.CreatedAt.Add(-24*time.Hour)))
+
+	reopened, err := OpenStore(path)
+	assert.NoError(t, err)
+	loaded, ok := reopened.Event("!room:example.com", "fingerprint")
+	assert.True(t, ok)
+	assert.Equal(t, event, loaded)
+
+	_, ok = reopened.Event("!other:example.com", "fingerprint")
+	assert.False(t, ok)
+	_, ok = reopened.Event("!room:example.com", "old")
+	assert.False(t, ok)
+
+	assert.NoError(t, reopened.DeleteEvent("!room:example.com", "fingerprint"))
+	reopened, err = OpenStore(path)
+	assert.NoError(t, err)
+	_, ok = reopened.Event("!room:example.com", "fingerprint")
+	assert.False(t, ok)
+}
+
+func TestStore_InMemory(t *testing.T) {
+	store, err := OpenStore("")
+	assert.NoError(t, err)
+	assert.NoError(t, store.SaveEvent(Event{Room: "room", Key: "key", EventID: "$event"}, time.Time{}))
+	loaded, ok := store.Event("room", "key")
+	assert.True(t, ok)
+	assert.Equal(t, "$event", loaded.EventID)
+}
+
+func TestStore_Notifications(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := Notification{
+		Room:      "!room:example.com",
+		EventID:   "$old",
+		Labels:    map[string]string{"alertname": "old"},
+		CreatedAt: createdAt.Add(-48 * time.Hour),
+	}
+	assert.NoError(t, store.SaveNotification(old, time.Time{}))
+	notification := Notification{
+		Room:        "!room:example.com",
+		EventID:     "$event",
+		Fingerprint: "fingerprint",
+		Labels:      map[string]string{"alertname": "down"},
+		ExternalURL: "http://alertmanager:9093",
+		CreatedAt:   createdAt,
+	}
+	assert.NoError(t, store.SaveNotification(notification, createdAt.Add(-24*time.Hour)))
+
+	reopened, err := OpenStore(path)
+	assert.NoError(t, err)
+	loaded, ok := reopened.Notification("!room:example.com", "$event")
+	assert.True(t, ok)
+	assert.Equal(t, notification, loaded)
+	_, ok = reopened.Notification("!room:example.com", "$old")
+	assert.False(t, ok)
+
+	later := Notification{Room: "!room:example.com", EventID: "$later", CreatedAt: createdAt.Add(time.Hour)}
+	assert.NoError(t, reopened.SaveNotification(later, time.Time{}))
+	assert.Equal(t, []Notification{notification, later}, reopened.Notifications("!room:example.com"))
+	assert.Empty(t, reopened.Notifications("!other:example.com"))
+
+	other := Notification{Room: "!other:example.com", EventID: "$other", CreatedAt: createdAt.Add(time.Minute)}
+	assert.NoError(t, reopened.SaveNotification(other, time.Time{}))
+	assert.Equal(t, []Notification{notification, other, later}, reopened.AllNotifications())
+}
+
+func TestStore_DeleteNotifications(t *testing.T) {
+	store, err := OpenStore("")
+	assert.NoError(t, err)
+	for _, notification := range []Notification{
+		{Room: "room", EventID: "$alert", Fingerprint: "fingerprint", GroupKey: "group"},
+		{Room: "room", EventID: "$other", Fingerprint: "other", GroupKey: "group"},
+		{Room: "room", EventID: "$group", GroupKey: "group"},
+	} {
+		assert.NoError(t, store.SaveNotification(notification, time.Time{}))
+	}
+
+	assert.NoError(t, store.DeleteNotifications("fingerprint", "group"))
+	_, ok := store.Notification("room", "$alert")
+	assert.False(t, ok)
+	_, ok = store.Notification("room", "$group")
+	assert.True(t, ok)
+
+	assert.NoError(t, store.DeleteNotifications("", "group"))
+	_, ok = store.Notification("room", "$group")
+	assert.False(t, ok)
+	_, ok = store.Notification("room", "$other")
+	assert.True(t, ok)
+}
+
+func TestStore_Acknowledgements(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+
+	acknowledgedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := Acknowledgement{Key: "old", AcknowledgedBy: "@bob:example.com", AcknowledgedAt: acknowledgedAt.Add(-48 * time.Hour)}
+	assert.NoError(t, store.SaveAcknowledgement(old, time.Time{}))
+	acknowledgement := Acknowledgement{Key: "fingerprint", AcknowledgedBy: "@alice:example.com", AcknowledgedAt: acknowledgedAt}
+	assert.NoError(t, store.SaveAcknowledgement(acknowledgement, acknowledgedAt.Add(-24*time.Hour)))
+
+	reopened, err := OpenStore(path)
+	assert.NoError(t, err)
+	loaded, ok := reopened.Acknowledgement("fingerprint")
+	assert.True(t, ok)
+	assert.Equal(t, acknowledgement, loaded)
+	_, ok = reopened.Acknowledgement("old")
+	assert.False(t, ok)
+
+	assert.NoError(t, reopened.DeleteAcknowledgement("fingerprint"))
+	_, ok = reopened.Acknowledgement("fingerprint")
+	assert.False(t, ok)
+	assert.NoError(t, reopened.DeleteAcknowledgement("unknown"))
+}
+
+func TestStore_Escalations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	store, err := OpenStore(path)
+	assert.NoError(t, err)
+
+	escalatedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := Escalation{Room: "!room:example.com", Key: "old", Steps: 1, EscalatedAt: escalatedAt.Add(-48 * time.Hour)}
+	assert.NoError(t, store.SaveEscalation(old, time.Time{}))
+	escalation := Escalation{Room: "!room:example.com", Key: "fingerprint", Steps: 2, EscalatedAt: escalatedAt}
+	assert.NoError(t, store.SaveEscalation(escalation, escalatedAt.Add(-24*time.Hour)))
+	other := Escalation{Room: "!other:example.com", Key: "fingerprint", Steps: 1, EscalatedAt: escalatedAt}
+	assert.NoError(t, store.SaveEscalation(other, time.Time{}))
+
+	reopened, err := OpenStore(path)
+	assert.NoError(t, err)
+	loaded, ok := reopened.Escalation("!room:example.com", "fingerprint")
+	assert.True(t, ok)
+	assert.Equal(t, escalation, loaded)
+	_, ok = reopened.Escalation("!room:example.com", "old")
+	assert.False(t, ok)
+
+	assert.NoError(t, reopened.DeleteEscalations("fingerprint"))
+	_, ok = reopened.Escalation("!room:example.com", "fingerprint")
+	assert.False(t, ok)
+	_, ok = reopened.Escalation("!other:example.com", "fingerprint")
+	assert.False(t, ok)
+	assert.NoError(t, reopened.DeleteEscalations("unknown"))
+}
@@ -10,11 +10,10 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
 	"github.com/metio/matrix-alertmanager-receiver/internal/config"
-	"github.com/metio/matrix-alertmanager-receiver/internal/handler"
-	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/metio/matrix-alertmanager-receiver/internal/queue"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -27,6 +26,7 @@ func main() {
 	var configPath = flag.String("config-path", "", "Path to configuration file")
 	var logLevel = flag.String("log-level", "info", "The log level to use (debug, info, warn, error)")
 	var version = flag.Bool("version", false, "Print version and exit")
+	var configWatchInterval = flag.Duration("config-watch-interval", 0, "Interval to check the configuration file for changes, disabled if 0")
 	flag.Parse()
 
 	if *version {
@@ -34,16 +34,34 @@ func main() {
 		os.Exit(0)
 	}
 
-	configureLogger(logLevel)
+	// commands print their results to stdout, therefore logs are written to stderr
+	logOutput := os.Stdout
+	if flag.NArg() > 0 {
+		logOutput = os.Stderr
+	}
+	configureLogger(logLevel, logOutput)
 	ctx := context.Background()
 
 	if configPath == nil || *configPath == "" {
 		slog.ErrorContext(ctx, "No --config-path parameter specified")
 		os.Exit(1)
 	}
+
+	switch command := flag.Arg(0); command {
+	case "":
+	case "validate":
+		os.Exit(validate(ctx, *configPath))
+	case "render":
+		os.Exit(render(ctx, *configPath, flag.Arg(1), flag.Arg(2)))
+	default:
+		slog.ErrorContext(ctx, "Unknown command", slog.String("command", command))
+		os.Exit(1)
+	}
+
 	slog.InfoContext(ctx, "CLI flags parsed",
 		slog.String("config-path", *configPath),
-		slog.String("log-level", *logLevel))
+		slog.String("log-level", *logLevel),
+		slog.Duration("config-watch-interval", *configWatchInterval))
 
 	configuration := config.ParseConfiguration(ctx, *configPath)
 	if configuration == nil {
@@ -52,34 +70,38 @@ func main() {
 	}
 	slog.InfoContext(ctx, "Configuration parsed", slog.Any("configuration", configuration.LogValue()))
 
-	sendingFunc := matrix.CreatingSendingFunc(ctx, configuration.Matrix)
-	slog.InfoContext(ctx, "Matrix sending function created")
-
-	templatingFunc := alertmanager.CreateTemplatingFunc(ctx, configuration.Templating)
-	slog.InfoContext(ctx, "Message templating function created")
+	store, err := state.OpenStore(configuration.State.File)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not open state store", slog.Any("error", err))
+		os.Exit(1)
+	}
+	slog.InfoContext(ctx, "State store opened")
 
-	extractorFunc := handler.CreateRoomExtractor(configuration.HTTPServer.AlertsPathPrefix)
-	slog.InfoContext(ctx, "Room extracting function created")
+	receiver := newReceiver(ctx, *configPath, store)
+	if err = receiver.apply(configuration); err != nil {
+		slog.ErrorContext(ctx, "Could not apply configuration", slog.Any("error", err))
+		os.Exit(1)
+	}
+	configReloadSuccessful.Set(1)
+	configReloadSuccessTimestamp.SetToCurrentTime()
 
-	var authorizerFunc handler.AuthorizerFunc
-	if configuration.HTTPServer.BasicPassword != "" {
-		slog.InfoContext(ctx, "Configuring basic authentication")
-		authorizerFunc = handler.CreateBasicAuthAuthorizer(configuration.HTTPServer.BasicUsername, configuration.HTTPServer.BasicPassword)
-	} else {
-		slog.InfoContext(ctx, "Allowing all incoming requests")
-		authorizerFunc = handler.CreateAlwaysAllowedAuthorizer()
+	if configuration.Queue.Enabled() {
+		queuedSendingFunc, err := queue.CreateQueuedSendingFunc(ctx, configuration.Queue, receiver.sendDirectly)
+		if err != nil {
+			slog.ErrorContext(ctx, "Could not create delivery queue", slog.Any("error", err))
+			os.Exit(1)
+		}
+		receiver.queuedSendingFunc = queuedSendingFunc
+		slog.InfoContext(ctx, "Delivery queue created")
 	}
-	slog.InfoContext(ctx, "Request authorizer function created")
 
-	http.HandleFunc(configuration.HTTPServer.AlertsPathPrefix, handler.AlertsHandler(ctx, sendingFunc, templatingFunc, extractorFunc, authorizerFunc))
-	if configuration.HTTPServer.MetricsEnabled {
-		slog.InfoContext(ctx, "Enabling metrics endpoint")
-		http.Handle(configuration.HTTPServer.MetricsPath, promhttp.Handler())
+	go receiver.reloadOnSignal()
+	if *configWatchInterval > 0 {
+		go receiver.reloadOnChange(*configWatchInterval)
 	}
-	slog.InfoContext(ctx, "Handlers configured")
 
 	var listenAddr = fmt.Sprintf("%v:%v", configuration.HTTPServer.Address, configuration.HTTPServer.Port)
-	err := http.ListenAndServe(listenAddr, nil)
+	err = http.ListenAndServe(listenAddr, receiver)
 	if errors.Is(err, http.ErrServerClosed) {
 		slog.DebugContext(ctx, "Server closed")
 		os.Exit(0)
@@ -89,7 +111,7 @@ func main() {
 	}
 }
 
-func configureLogger(logLevel *string) {
+func configureLogger(logLevel *string, output io.Writer) {
 	var level slog.Level
 	switch strings.ToLower(*logLevel) {
 	case "error":
@@ -102,7 +124,7 @@ func configureLogger(logLevel *string) {
 	default:
 		level = slog.LevelInfo
 	}
-	logHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	logHandler := slog.NewJSONHandler(output, &slog.HandlerOptions{
 		Level: level,
 	})
 	slog.SetDefault(slog.New(logHandler))
@@ -0,0 +1,262 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/handler"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+	"github.com/metio/matrix-alertmanager-receiver/internal/state"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	configReloadSuccessful = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_alertmanager_receiver_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful",
+	})
+	configReloadSuccessTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "matrix_alertmanager_receiver_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	})
+)
+
+// components holds everything created from a single configuration.
+type components struct {
+	configuration *config.Configuration
+	sendingFunc   matrix.SendingFunc
+	handler       http.Handler
+}
+
+// receiver serves requests with the components of the currently active configuration, which can be swapped
+// atomically while serving.
+type receiver struct {
+	ctx               context.Context
+	configPath        string
+	store             *state.Store
+	queuedSendingFunc matrix.SendingFunc
+	current           atomic.Pointer[components]
+	reloadMutex       sync.Mutex
+}
+
+func newReceiver(ctx context.Context, configPath string, store *state.Store) *receiver {
+	return &receiver{
+		ctx:        ctx,
+		configPath: configPath,
+		store:      store,
+	}
+}
+
+func (r *receiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	r.current.Load().handler.ServeHTTP(writer, request)
+}
+
+// sendDirectly sends a message to Matrix using the currently active configuration.
+func (r *receiver) sendDirectly(message matrix.Message) error {
+	return r.current.Load().sendingFunc(message)
+}
+
+// send puts a message into the delivery queue if enabled, otherwise sends it directly.
+func (r *receiver) send(message matrix.Message) error {
+	if r.queuedSendingFunc != nil {
+		return r.queuedSendingFunc(message)
+	}
+	return r.sendDirectly(message)
+}
+
+// apply creates all components for the given configuration and activates them only if all of them could be created.
+func (r *receiver) apply(configuration *config.Configuration) error {
+	ctx := r.ctx
+
+	sendingFunc, err := matrix.CreatingSendingFunc(ctx, configuration.Matrix, r.store)
+	if err != nil {
+		return fmt.Errorf("could not create Matrix sending function: %w", err)
+	}
+	slog.InfoContext(ctx, "Matrix sending function created")
+
+	renderingFunc, err := createRenderingFunc(ctx, configuration)
+	if err != nil {
+		return err
+	}
+
+	extractorFunc := handler.CreateRoomExtractor(configuration.HTTPServer.AlertsPathPrefix)
+	slog.InfoContext(ctx, "Room extracting function created")
+
+	var authorizerFunc handler.AuthorizerFunc
+	if configuration.HTTPServer.BasicPassword != "" {
+		slog.InfoContext(ctx, "Configuring basic authentication")
+		authorizerFunc = handler.CreateBasicAuthAuthorizer(configuration.HTTPServer.BasicUsername, configuration.HTTPServer.BasicPassword)
+	} else {
+		slog.InfoContext(ctx, "Allowing all incoming requests")
+		authorizerFunc = handler.CreateAlwaysAllowedAuthorizer()
+	}
+	slog.InfoContext(ctx, "Request authorizer function created")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(configuration.HTTPServer.AlertsPathPrefix, handler.AlertsHandler(ctx, r.send, renderingFunc, extractorFunc, authorizerFunc))
+	if configuration.HTTPServer.PreviewEnabled {
+		slog.InfoContext(ctx, "Enabling preview endpoint")
+		explainingFunc := alertmanager.CreateExplainingFunc(ctx, configuration.Templating)
+		previewExtractorFunc := handler.CreateRoomExtractor(configuration.HTTPServer.PreviewPathPrefix)
+		mux.HandleFunc(configuration.HTTPServer.PreviewPathPrefix, handler.PreviewHandler(ctx, renderingFunc, explainingFunc, previewExtractorFunc, authorizerFunc))
+	}
+	if configuration.HTTPServer.MetricsEnabled {
+		slog.InfoContext(ctx, "Enabling metrics endpoint")
+		mux.Handle(configuration.HTTPServer.MetricsPath, promhttp.Handler())
+	}
+	slog.InfoContext(ctx, "Handlers configured")
+
+	if err := matrix.EnableInteractions(ctx, configuration, r.store); err != nil {
+		return fmt.Errorf("could not enable interactions: %w", err)
+	}
+
+	r.current.Store(&components{
+		configuration: configuration,
+		sendingFunc:   sendingFunc,
+		handler:       mux,
+	})
+	return nil
+}
+
+// reload parses the configuration file again and activates it. The previous configuration stays active in case
+// the new configuration is invalid.
+func (r *receiver) reload() {
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+	ctx := r.ctx
+
+	slog.InfoContext(ctx, "Reloading configuration", slog.String("config-path", r.configPath))
+	configuration := config.ParseConfiguration(ctx, r.configPath)
+	if configuration == nil {
+		configReloadSuccessful.Set(0)
+		slog.ErrorContext(ctx, "Could not parse configuration, keeping previous configuration")
+		return
+	}
+	warnAboutRestartRequired(ctx, r.current.Load().configuration, configuration)
+	if err := r.apply(configuration); err != nil {
+		configReloadSuccessful.Set(0)
+		slog.ErrorContext(ctx, "Could not apply configuration, keeping previous configuration", slog.Any("error", err))
+		return
+	}
+	configReloadSuccessful.Set(1)
+	configReloadSuccessTimestamp.SetToCurrentTime()
+	slog.InfoContext(ctx, "Configuration reloaded", slog.Any("configuration", configuration.LogValue()))
+}
+
+func warnAboutRestartRequired(ctx context.Context, previous *config.Configuration, next *config.Configuration) {
+	if previous.HTTPServer.Address != next.HTTPServer.Address || previous.HTTPServer.Port != next.HTTPServer.Port {
+		slog.WarnContext(ctx, "Changes to the HTTP address and port require a restart")
+	}
+	if previous.Queue != next.Queue {
+		slog.WarnContext(ctx, "Changes to the delivery queue require a restart")
+	}
+	if previous.State != next.State {
+		slog.WarnContext(ctx, "Changes to the state file require a restart")
+	}
+	if previous.Matrix.Encryption != next.Matrix.Encryption {
+		slog.WarnContext(ctx, "Changes to the encryption settings require a restart")
+	}
+	if (next.Matrix.Encryption.Enabled() || next.Matrix.Interactive()) && (previous.Matrix.HomeServerURL != next.Matrix.HomeServerURL ||
+		previous.Matrix.UserID != next.Matrix.UserID ||
+		previous.Matrix.AccessToken != next.Matrix.AccessToken ||
+		previous.Matrix.Proxy != next.Matrix.Proxy) {
+		slog.WarnContext(ctx, "Changes to the Matrix connection require a restart while encryption or interactions are enabled")
+	}
+}
+
+func (r *receiver) reloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for {
+		select {
+		case <-signals:
+			r.reload()
+		case <-r.ctx.Done():
+			signal.Stop(signals)
+			return
+		}
+	}
+}
+
+// reloadOnChange periodically checks the content of the configuration file and the secret and template files it refers
+// to and reloads the configuration once any of them changed. This works with symlinked files as well, e.g. mounted
+// Kubernetes ConfigMaps.
+func (r *receiver) reloadOnChange(interval time.Duration) {
+	ctx := r.ctx
+	slog.InfoContext(ctx, "Watching configuration file for changes", slog.Duration("interval", interval))
+	previous := checksum(r.watchedFiles()...)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			current := checksum(r.watchedFiles()...)
+			if current != nil && !bytes.Equal(previous, current) {
+				previous = current
+				r.reload()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *receiver) watchedFiles() []string {
+	files := []string{r.configPath}
+	configuration := r.current.Load().configuration
+	if configuration.Matrix.AccessTokenFile != "" {
+		files = append(files, configuration.Matrix.AccessTokenFile)
+	}
+	if configuration.HTTPServer.BasicPasswordFile != "" {
+		files = append(files, configuration.HTTPServer.BasicPasswordFile)
+	}
+	templating := configuration.Templating
+	templateFiles := []string{templating.FiringFile, templating.ResolvedFile, templating.GroupFile, templating.FiringTextFile, templating.ResolvedTextFile}
+	for _, named := range templating.Templates {
+		templateFiles = append(templateFiles, named.FiringFile, named.ResolvedFile, named.FiringTextFile, named.ResolvedTextFile)
+	}
+	for _, templateFile := range templateFiles {
+		if templateFile != "" {
+			files = append(files, templateFile)
+		}
+	}
+	if templating.Files != "" {
+		// added and removed files are detected as well, since the checksum includes the file names
+		matches, _ := filepath.Glob(templating.Files)
+		files = append(files, matches...)
+	}
+	return files
+}
+
+func checksum(paths ...string) []byte {
+	hash := sha256.New()
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		hash.Write([]byte(path))
+		hash.Write(content)
+	}
+	return hash.Sum(nil)
+}
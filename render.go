@@ -0,0 +1,113 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+	"github.com/metio/matrix-alertmanager-receiver/internal/handler"
+	"github.com/metio/matrix-alertmanager-receiver/internal/matrix"
+)
+
+func createRenderingFunc(ctx context.Context, configuration *config.Configuration) (handler.RenderingFunc, error) {
+	templatingFunc, err := alertmanager.CreateTemplatingFunc(ctx, configuration.Templating)
+	if err != nil {
+		return nil, fmt.Errorf("could not create templating function: %w", err)
+	}
+	slog.InfoContext(ctx, "Message templating function created")
+
+	groupTemplatingFunc, err := alertmanager.CreateGroupTemplatingFunc(ctx, configuration.Templating)
+	if err != nil {
+		return nil, fmt.Errorf("could not create group templating function: %w", err)
+	}
+	slog.InfoContext(ctx, "Group templating function created")
+
+	groupingFunc := handler.CreateGroupingFunc(configuration.Templating)
+	slog.InfoContext(ctx, "Grouping function created")
+
+	messageTypeFunc := handler.CreateMessageTypeFunc(configuration.Templating)
+	slog.InfoContext(ctx, "Message type function created")
+
+	routingFunc, err := handler.CreateRoutingFunc(configuration.Routes)
+	if err != nil {
+		return nil, fmt.Errorf("could not create routing function: %w", err)
+	}
+	slog.InfoContext(ctx, "Routing function created")
+
+	roomResolverFunc := matrix.CreateRoomResolver(configuration.Matrix)
+	slog.InfoContext(ctx, "Room resolving function created")
+
+	renderingFunc := handler.CreateRenderingFunc(ctx, templatingFunc, groupTemplatingFunc, groupingFunc, messageTypeFunc, routingFunc, roomResolverFunc)
+	slog.InfoContext(ctx, "Rendering function created")
+	return renderingFunc, nil
+}
+
+// render prints all messages that would be sent for the webhook payload stored in the given file without contacting
+// the Matrix homeserver. The room is the part of the URL path after the alerts path prefix. It returns the exit code
+// of the process.
+func render(ctx context.Context, configPath string, payloadPath string, room string) int {
+	if payloadPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: render <payload-file> [room]")
+		return 1
+	}
+	configuration := config.ParseConfiguration(ctx, configPath)
+	if configuration == nil {
+		fmt.Fprintf(os.Stderr, "could not parse configuration %s\n", configPath)
+		return 1
+	}
+	renderingFunc, err := createRenderingFunc(ctx, configuration)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	file, err := os.Open(payloadPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not open payload: %v\n", err)
+		return 1
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	payload, err := alertmanager.DecodePayload(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not decode payload: %v\n", err)
+		return 1
+	}
+
+	notifications := renderingFunc(payload, room)
+	if len(notifications) == 0 {
+		fmt.Fprintln(os.Stderr, "no room selected, specify the room as used in the URL path of the alerts endpoint")
+		return 1
+	}
+	exitCode := 0
+	for index, notification := range notifications {
+		if index > 0 {
+			fmt.Println()
+		}
+		var alerts []string
+		for _, alert := range notification.Alerts {
+			alerts = append(alerts, fmt.Sprintf("%s (%s)", alert.Fingerprint, alert.Status))
+		}
+		fmt.Printf("Room: %s\n", notification.Message.Room)
+		fmt.Printf("Alerts: %s\n", strings.Join(alerts, ", "))
+		if notification.Error != nil {
+			fmt.Printf("Error: %v\n", notification.Error)
+			exitCode = 1
+			continue
+		}
+		content := notification.Message.Content()
+		fmt.Printf("Message type: %s\n", content.MsgType)
+		fmt.Printf("HTML:\n%s\n", notification.Message.HTML)
+		fmt.Printf("Text:\n%s\n", content.Body)
+	}
+	return exitCode
+}
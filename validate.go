@@ -0,0 +1,32 @@
+/*
+ * SPDX-FileCopyrightText: The matrix-alertmanager-receiver Authors
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/metio/matrix-alertmanager-receiver/internal/alertmanager"
+	"github.com/metio/matrix-alertmanager-receiver/internal/config"
+)
+
+// validate checks the configuration file strictly, compiles and renders all templates against a sample payload, and
+// prints every problem found. It returns the exit code of the process.
+func validate(ctx context.Context, configPath string) int {
+	configuration, problems := config.CheckConfiguration(configPath)
+	if configuration != nil {
+		problems = append(problems, alertmanager.CheckTemplates(ctx, configuration.Templating)...)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problem(s) found in %s\n", len(problems), configPath)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", configPath)
+	return 0
+}